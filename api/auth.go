@@ -6,23 +6,42 @@ package api
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"net/http"
 )
 
-type publicBearerToken string
+var (
+	// ErrNoCredentials is returned when no credentials are available
+	// to authorize a request.
+	ErrNoCredentials = errors.New("no credentials available")
+	// ErrUnauthorized is returned when the server rejects a request's
+	// credentials, ie. with http.StatusUnauthorized.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is returned when the server refuses access to the
+	// requested resource using the supplied credentials, ie. with
+	// http.StatusForbidden.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Authorizer is used to add authentication information to
+// outgoing requests.
+type Authorizer interface {
+	Authorize(ctx context.Context, req *http.Request) error
+}
 
-var bearerTokenKey = publicBearerToken("publicToken")
+// PublicToken is an Authorizer that uses a protocols.io public
+// access token.
+type PublicToken string
 
-func WithPublicToken(ctx context.Context, token string) context.Context {
-	return context.WithValue(ctx, bearerTokenKey, token)
+// Authorize implements Authorizer.
+func (t PublicToken) Authorize(ctx context.Context, req *http.Request) error {
+	return addAuthHeader(req, string(t))
 }
 
-func addAuthHeader(ctx context.Context, req *http.Request) error {
-	v := ctx.Value(bearerTokenKey).(string)
-	if len(v) > 0 {
-		req.Header.Add("Bearer", v)
-		return nil
+func addAuthHeader(req *http.Request, token string) error {
+	if len(token) == 0 {
+		return ErrNoCredentials
 	}
-	return fmt.Errorf("no authentication information was found in the context.Context")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
 }
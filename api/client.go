@@ -0,0 +1,132 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// DefaultBaseURL is the base URL for the protocols.io API.
+const DefaultBaseURL = "https://www.protocols.io/api"
+
+// Endpoints represents the URLs used for each of the API calls
+// supported by Client.
+type Endpoints struct {
+	ListProtocolsV3 string
+	GetProtocolV4   string
+}
+
+// EndpointsFor returns the Endpoints for the specified base URL.
+func EndpointsFor(baseURL string) Endpoints {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	return Endpoints{
+		ListProtocolsV3: baseURL + "/v3/protocols",
+		GetProtocolV4:   baseURL + "/v4/protocols",
+	}
+}
+
+// Client provides access to the protocols.io API. It is safe for
+// concurrent use.
+type Client struct {
+	httpClient  *http.Client
+	endpoints   Endpoints
+	auth        Authorizer
+	retryPolicy RetryPolicy
+	retryHook   RetryHook
+	limiter     RateLimiter
+	logger      *log.Logger
+}
+
+// Option represents an option to NewClient.
+type Option func(c *Client)
+
+// WithHTTPClient specifies the http.Client to use, the default
+// is http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithBaseURL specifies the base URL to use for all endpoints.
+func WithBaseURL(u string) Option {
+	return func(c *Client) {
+		c.endpoints = EndpointsFor(u)
+	}
+}
+
+// WithEndpoints overrides the URLs used for individual endpoints, only
+// non-empty values are used.
+func WithEndpoints(ep Endpoints) Option {
+	return func(c *Client) {
+		if len(ep.ListProtocolsV3) > 0 {
+			c.endpoints.ListProtocolsV3 = ep.ListProtocolsV3
+		}
+		if len(ep.GetProtocolV4) > 0 {
+			c.endpoints.GetProtocolV4 = ep.GetProtocolV4
+		}
+	}
+}
+
+// WithAuth specifies the Authorizer to use for all requests.
+func WithAuth(a Authorizer) Option {
+	return func(c *Client) {
+		c.auth = a
+	}
+}
+
+// WithRetryPolicy specifies the RetryPolicy to use, the default
+// is that returned by DefaultRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) {
+		c.retryPolicy = p
+	}
+}
+
+// WithRetryHook specifies a function to be called before every retry.
+func WithRetryHook(h RetryHook) Option {
+	return func(c *Client) {
+		c.retryHook = h
+	}
+}
+
+// WithRateLimiter specifies a RateLimiter to be used for every request,
+// including retries. The default is to not limit the rate of requests.
+func WithRateLimiter(rl RateLimiter) Option {
+	return func(c *Client) {
+		c.limiter = rl
+	}
+}
+
+// WithLogger specifies the logger to use for informational messages.
+// The default is to discard all such messages.
+func WithLogger(l *log.Logger) Option {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+// NewClient returns a new Client configured with the supplied options.
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		httpClient:  http.DefaultClient,
+		endpoints:   EndpointsFor(DefaultBaseURL),
+		retryPolicy: DefaultRetryPolicy(),
+		limiter:     unlimited{},
+		logger:      log.New(io.Discard, "", 0),
+	}
+	for _, fn := range opts {
+		fn(c)
+	}
+	return c
+}
+
+// Endpoints returns the endpoints used by this client.
+func (c *Client) Endpoints() Endpoints {
+	return c.endpoints
+}
@@ -0,0 +1,126 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when the requested protocol does not exist.
+	ErrNotFound = errors.New("protocol not found")
+	// ErrPrivate is returned when the requested protocol is private and
+	// not accessible using the supplied credentials.
+	ErrPrivate = errors.New("protocol is private")
+	// ErrRemoved is returned when the requested protocol has been removed
+	// or deleted.
+	ErrRemoved = errors.New("protocol has been removed")
+)
+
+// APIError represents an error reported by the protocols.io API, either
+// via a non-zero status_code in the response envelope or via an http
+// status code. It supports errors.Is for ErrNotFound, ErrPrivate,
+// ErrRemoved, ErrUnauthorized, ErrForbidden and ErrTooManyRequests.
+type APIError struct {
+	URL        string
+	Code       int    // The status_code returned in the response envelope.
+	Message    string // The error_message returned in the response envelope.
+	HTTPStatus int
+}
+
+// Error implements error.
+func (e *APIError) Error() string {
+	var out strings.Builder
+	out.WriteString(e.URL)
+	if e.HTTPStatus != 0 {
+		fmt.Fprintf(&out, ": %v %v", e.HTTPStatus, http.StatusText(e.HTTPStatus))
+	}
+	if e.Code != 0 {
+		fmt.Fprintf(&out, ": status_code: %v", e.Code)
+	}
+	if len(e.Message) > 0 {
+		fmt.Fprintf(&out, ": %v", e.Message)
+	}
+	return out.String()
+}
+
+// Status codes returned in the response envelope, as documented by
+// protocols.io, that correspond to ErrNotFound, ErrPrivate and
+// ErrRemoved.
+const (
+	StatusProtocolNotFound = 1210
+	StatusProtocolPrivate  = 1211
+	StatusProtocolRemoved  = 1212
+)
+
+// Is implements errors.Is. ErrNotFound, ErrPrivate and ErrRemoved are
+// determined by the status_code in the response envelope, or for
+// ErrNotFound and ErrRemoved, by the http status code. The error message
+// is intended for display only and is not used.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.HTTPStatus == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.HTTPStatus == http.StatusForbidden
+	case ErrTooManyRequests:
+		return e.HTTPStatus == http.StatusTooManyRequests
+	case ErrNotFound:
+		return e.Code == StatusProtocolNotFound || e.HTTPStatus == http.StatusNotFound
+	case ErrRemoved:
+		return e.Code == StatusProtocolRemoved || e.HTTPStatus == http.StatusGone
+	case ErrPrivate:
+		return e.Code == StatusProtocolPrivate
+	}
+	return false
+}
+
+// IsUnavailable returns true if err indicates that a protocol does
+// not exist, is private or has been removed.
+func IsUnavailable(err error) bool {
+	return errors.Is(err, ErrNotFound) ||
+		errors.Is(err, ErrPrivate) ||
+		errors.Is(err, ErrRemoved)
+}
+
+type envelope struct {
+	StatusCode   int    `json:"status_code"`
+	ErrorMessage string `json:"error_message"`
+}
+
+// fromEnvelope fills in the code and message from the response
+// envelope, if any, contained in body.
+func (e *APIError) fromEnvelope(body []byte) {
+	var env envelope
+	if json.Unmarshal(body, &env) != nil {
+		return
+	}
+	e.Code = env.StatusCode
+	if len(env.ErrorMessage) > 0 {
+		e.Message = env.ErrorMessage
+	}
+}
+
+// checkEnvelope returns an APIError if body contains a response
+// envelope with a non-zero status_code.
+func checkEnvelope(url string, body []byte) error {
+	var env envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return err
+	}
+	if env.StatusCode == 0 {
+		return nil
+	}
+	return &APIError{
+		URL:        url,
+		Code:       env.StatusCode,
+		Message:    env.ErrorMessage,
+		HTTPStatus: http.StatusOK,
+	}
+}
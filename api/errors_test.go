@@ -0,0 +1,37 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestAPIErrorIs(t *testing.T) {
+	for i, tc := range []struct {
+		err    *APIError
+		target error
+		is     bool
+	}{
+		{&APIError{HTTPStatus: http.StatusNotFound}, ErrNotFound, true},
+		{&APIError{HTTPStatus: http.StatusOK, Code: StatusProtocolNotFound}, ErrNotFound, true},
+		{&APIError{HTTPStatus: http.StatusOK, Code: StatusProtocolPrivate}, ErrPrivate, true},
+		{&APIError{HTTPStatus: http.StatusOK, Code: StatusProtocolRemoved}, ErrRemoved, true},
+		{&APIError{HTTPStatus: http.StatusGone}, ErrRemoved, true},
+		// The message is for display only.
+		{&APIError{HTTPStatus: http.StatusOK, Code: 1, Message: "protocol not found"}, ErrNotFound, false},
+		{&APIError{HTTPStatus: http.StatusOK, Code: 1, Message: "permission denied"}, ErrPrivate, false},
+		{&APIError{HTTPStatus: http.StatusBadRequest, Code: 1, Message: "has been removed"}, ErrRemoved, false},
+		{&APIError{HTTPStatus: http.StatusForbidden}, ErrForbidden, true},
+		{&APIError{HTTPStatus: http.StatusForbidden}, ErrPrivate, false},
+		{&APIError{HTTPStatus: http.StatusUnauthorized}, ErrUnauthorized, true},
+		{&APIError{HTTPStatus: http.StatusTooManyRequests}, ErrTooManyRequests, true},
+	} {
+		if got, want := errors.Is(tc.err, tc.target), tc.is; got != want {
+			t.Errorf("%v: %v: %v: got %v, want %v", i, tc.err, tc.target, got, want)
+		}
+	}
+}
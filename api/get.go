@@ -8,52 +8,101 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"strconv"
 	"time"
 )
 
+// ErrTooManyRequests is returned when the server continues to
+// rate limit requests after all retries have been exhausted.
 var ErrTooManyRequests = errors.New("too many requests")
 
-func Get[T any](ctx context.Context, url string) (T, []byte, error) {
-	initialDelay := time.Minute
-	maxDelay := time.Minute * 16
-	delay := initialDelay
-	for {
-		var m T
+// Get issues a GET request for the specified URL, retrying failed
+// requests as determined by the client's RetryPolicy. It returns the
+// body of the response.
+func (c *Client) Get(ctx context.Context, url string) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
 		r, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 		if err != nil {
-			return m, nil, err
+			return nil, err
 		}
-		if err := addAuthHeader(ctx, r); err != nil {
-			return m, nil, err
+		if c.auth == nil {
+			return nil, ErrNoCredentials
 		}
-		res, err := http.DefaultClient.Do(r)
-		if err != nil {
-			return m, nil, err
+		if err := c.auth.Authorize(ctx, r); err != nil {
+			return nil, err
 		}
-		if res.StatusCode == http.StatusTooManyRequests {
-			if delay >= maxDelay {
-				return m, nil, ErrTooManyRequests
+		res, err := c.httpClient.Do(r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			delay, retry := c.retryPolicy.Retry(attempt, nil, err)
+			if !retry {
+				return nil, err
+			}
+			if err := c.wait(ctx, RetryEvent{URL: url, Attempt: attempt, Err: err, Delay: delay}); err != nil {
+				return nil, err
 			}
-			fmt.Printf("too many requests: sleeping for %v\n", delay)
-			time.Sleep(delay)
-			delay *= 2
 			continue
 		}
-		if delay != initialDelay {
-			fmt.Printf("succeeded after retry with delay of %v\n", delay)
-		}
 		body, err := io.ReadAll(res.Body)
 		res.Body.Close()
 		if err != nil {
-			return m, body, err
+			return body, err
+		}
+		if res.StatusCode < 300 {
+			if attempt > 1 {
+				c.logger.Printf("%v: succeeded after %v attempts\n", url, attempt)
+			}
+			return body, nil
 		}
-		return parseJSON[T](body)
+		delay, retry := c.retryPolicy.Retry(attempt, res, nil)
+		if !retry {
+			apiErr := &APIError{URL: url, HTTPStatus: res.StatusCode}
+			apiErr.fromEnvelope(body)
+			return body, apiErr
+		}
+		if err := c.wait(ctx, RetryEvent{URL: url, Attempt: attempt, StatusCode: res.StatusCode, Delay: delay}); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) wait(ctx context.Context, ev RetryEvent) error {
+	if c.retryHook != nil {
+		c.retryHook(ev)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(ev.Delay):
+		return nil
+	}
+}
+
+// getJSON issues a GET request and parses the response, returning an
+// APIError if the response envelope contains a non-zero status_code.
+func getJSON[T any](ctx context.Context, c *Client, url string) (T, []byte, error) {
+	body, err := c.Get(ctx, url)
+	if err != nil {
+		var t T
+		return t, body, err
+	}
+	t, body, err := parseJSON[T](body)
+	if err != nil {
+		return t, body, err
 	}
+	return t, body, checkEnvelope(url, body)
+}
+
+// GetProtocol returns the payload for the specified protocol using
+// the v4 API.
+func (c *Client) GetProtocol(ctx context.Context, id string) (Payload, []byte, error) {
+	return getJSON[Payload](ctx, c, c.endpoints.GetProtocolV4+"/"+id)
 }
 
 func parseJSON[T any](s []byte) (T, []byte, error) {
@@ -75,26 +124,3 @@ type Pagination struct {
 	Last         int64       `json:"last"`
 	ChangedOn    interface{} `json:"changed_on"`
 }
-
-func (p Pagination) Done() bool {
-	return p.CurrentPage == p.TotalPages
-}
-
-func (p Pagination) PageInfo() (next, total int, done bool, err error) {
-	if p.Done() {
-		return 0, int(p.TotalPages), true, nil
-	}
-	u, err := url.Parse(p.NextPage)
-	if err != nil {
-		return 0, int(p.TotalPages), false, err
-	}
-	np := u.Query().Get("page_id")
-	if len(np) == 0 {
-		return 0, int(p.TotalPages), false, fmt.Errorf("%v: failed to find page_id parameter in %v: %#v", p.NextPage, u.String(), p)
-	}
-	npi, err := strconv.Atoi(np)
-	if err != nil {
-		return 0, int(p.TotalPages), false, fmt.Errorf("failed to parse %q: %v", np, err)
-	}
-	return npi, int(p.TotalPages), false, nil
-}
@@ -5,7 +5,10 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"net/url"
+	"strings"
 )
 
 type ListProtocolsV3 struct {
@@ -15,28 +18,13 @@ type ListProtocolsV3 struct {
 	Total        int64             `json:"total"`
 	TotalPages   int64             `json:"total_pages"`
 	TotalResults int64             `json:"total_results"`
+	StatusCode   int               `json:"status_code"`
 }
 
 type Payload struct {
-	Payload    json.RawMessage `json:"payload"`
-	StatusCode int             `json:"status_code"`
-}
-
-type Creator struct {
-	Name       string
-	Username   string
-	Affilation string
-}
-
-type Protocol struct {
-	ID          int64  `json:"id"`
-	URI         string `json:"uri"`
-	URL         string `json:"url"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	VersionID   int    `json:"version_id"`
-	CreatedOn   int    `json:"created_on"`
-	Creator     Creator
+	Payload      json.RawMessage `json:"payload"`
+	StatusCode   int             `json:"status_code"`
+	ErrorMessage string          `json:"error_message"`
 }
 
 func ParsePayload[T any](buf []byte) (T, error) {
@@ -48,3 +36,10 @@ func ParsePayload[T any](buf []byte) (T, error) {
 	err := json.Unmarshal(payload.Payload, &t)
 	return t, err
 }
+
+// ListProtocols returns a single page of protocols using the v3 API
+// and the supplied query parameters.
+func (c *Client) ListProtocols(ctx context.Context, params url.Values) (ListProtocolsV3, []byte, error) {
+	u := strings.TrimSuffix(c.endpoints.ListProtocolsV3, "/?")
+	return getJSON[ListProtocolsV3](ctx, c, u+"?"+params.Encode())
+}
@@ -0,0 +1,331 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultAuthURL is the protocols.io OAuth2 authorization endpoint.
+	DefaultAuthURL = DefaultBaseURL + "/v3/oauth/authorize"
+	// DefaultTokenURL is the protocols.io OAuth2 token endpoint.
+	DefaultTokenURL = DefaultBaseURL + "/v3/oauth/token"
+)
+
+// expiryDelta is subtracted from a token's expiry time to allow for
+// clock skew and request latency.
+const expiryDelta = 30 * time.Second
+
+// Token represents an OAuth2 token.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// Valid returns true if the token has an access token that has
+// not expired. A zero Expiry is interpreted as never expiring.
+func (t Token) Valid() bool {
+	if len(t.AccessToken) == 0 {
+		return false
+	}
+	return t.Expiry.IsZero() || time.Now().Add(expiryDelta).Before(t.Expiry)
+}
+
+// TokenSource represents a source of OAuth2 tokens.
+type TokenSource interface {
+	Token(ctx context.Context) (Token, error)
+}
+
+// TokenAuthorizer returns an Authorizer that uses the tokens obtained
+// from the supplied TokenSource.
+func TokenAuthorizer(ts TokenSource) Authorizer {
+	return &tokenAuthorizer{ts}
+}
+
+type tokenAuthorizer struct {
+	ts TokenSource
+}
+
+// Authorize implements Authorizer.
+func (ta *tokenAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	tok, err := ta.ts.Token(ctx)
+	if err != nil {
+		return err
+	}
+	return addAuthHeader(req, tok.AccessToken)
+}
+
+// OAuth2Config represents the configuration required to obtain OAuth2
+// tokens from protocols.io using either the client credentials or
+// authorization code flows.
+type OAuth2Config struct {
+	ClientID     string
+	ClientSecret string
+	AuthURL      string // Defaults to DefaultAuthURL.
+	TokenURL     string // Defaults to DefaultTokenURL.
+	RedirectURL  string
+	Scopes       []string
+	HTTPClient   *http.Client // Defaults to http.DefaultClient.
+}
+
+func (c OAuth2Config) authURL() string {
+	if len(c.AuthURL) > 0 {
+		return c.AuthURL
+	}
+	return DefaultAuthURL
+}
+
+func (c OAuth2Config) tokenURL() string {
+	if len(c.TokenURL) > 0 {
+		return c.TokenURL
+	}
+	return DefaultTokenURL
+}
+
+func (c OAuth2Config) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// AuthCodeURL returns the URL that a user should visit to authorize
+// this client; state is returned unchanged in the redirect.
+func (c OAuth2Config) AuthCodeURL(state string) string {
+	v := url.Values{}
+	v.Set("client_id", c.ClientID)
+	v.Set("response_type", "code")
+	if len(c.RedirectURL) > 0 {
+		v.Set("redirect_url", c.RedirectURL)
+	}
+	if len(c.Scopes) > 0 {
+		v.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	if len(state) > 0 {
+		v.Set("state", state)
+	}
+	return c.authURL() + "?" + v.Encode()
+}
+
+// Exchange exchanges an authorization code for a token.
+func (c OAuth2Config) Exchange(ctx context.Context, code string) (Token, error) {
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	if len(c.RedirectURL) > 0 {
+		v.Set("redirect_url", c.RedirectURL)
+	}
+	return c.retrieveToken(ctx, v)
+}
+
+// Refresh obtains a new token using the supplied refresh token.
+func (c OAuth2Config) Refresh(ctx context.Context, refreshToken string) (Token, error) {
+	v := url.Values{}
+	v.Set("grant_type", "refresh_token")
+	v.Set("refresh_token", refreshToken)
+	return c.retrieveToken(ctx, v)
+}
+
+// ClientCredentials returns a TokenSource that obtains tokens using
+// the client credentials flow, refreshing them as they expire.
+func (c OAuth2Config) ClientCredentials() TokenSource {
+	return &refreshingSource{
+		cfg: c,
+		fetch: func(ctx context.Context) (Token, error) {
+			v := url.Values{}
+			v.Set("grant_type", "client_credentials")
+			if len(c.Scopes) > 0 {
+				v.Set("scope", strings.Join(c.Scopes, " "))
+			}
+			return c.retrieveToken(ctx, v)
+		},
+	}
+}
+
+// TokenSource returns a TokenSource that returns tok until it expires
+// and then uses its refresh token to obtain a new one.
+func (c OAuth2Config) TokenSource(tok Token) TokenSource {
+	return &refreshingSource{cfg: c, tok: tok}
+}
+
+type refreshingSource struct {
+	cfg   OAuth2Config
+	fetch func(ctx context.Context) (Token, error)
+	mu    sync.Mutex
+	tok   Token
+}
+
+// Token implements TokenSource.
+func (rs *refreshingSource) Token(ctx context.Context) (Token, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.tok.Valid() {
+		return rs.tok, nil
+	}
+	var (
+		tok Token
+		err error
+	)
+	switch {
+	case len(rs.tok.RefreshToken) > 0:
+		tok, err = rs.cfg.Refresh(ctx, rs.tok.RefreshToken)
+		if err != nil && rs.fetch != nil {
+			tok, err = rs.fetch(ctx)
+		}
+	case rs.fetch != nil:
+		tok, err = rs.fetch(ctx)
+	default:
+		return Token{}, fmt.Errorf("token has expired and cannot be refreshed")
+	}
+	if err != nil {
+		return Token{}, err
+	}
+	if len(tok.RefreshToken) == 0 {
+		tok.RefreshToken = rs.tok.RefreshToken
+	}
+	rs.tok = tok
+	return tok, nil
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+func (c OAuth2Config) retrieveToken(ctx context.Context, v url.Values) (Token, error) {
+	v.Set("client_id", c.ClientID)
+	v.Set("client_secret", c.ClientSecret)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.tokenURL(), strings.NewReader(v.Encode()))
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return Token{}, err
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return Token{}, err
+	}
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return Token{}, fmt.Errorf("%v: failed to decode token response: %v: %s", res.Status, err, body)
+	}
+	if len(tr.Error) > 0 {
+		return Token{}, fmt.Errorf("%v: %v: %v", res.Status, tr.Error, tr.Description)
+	}
+	if res.StatusCode != http.StatusOK || len(tr.AccessToken) == 0 {
+		return Token{}, fmt.Errorf("%v: no access token returned: %s", res.Status, body)
+	}
+	tok := Token{
+		AccessToken:  tr.AccessToken,
+		TokenType:    tr.TokenType,
+		RefreshToken: tr.RefreshToken,
+	}
+	if tr.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
+
+// ReadTokenFile reads a token previously written by WriteTokenFile.
+func ReadTokenFile(filename string) (Token, error) {
+	var tok Token
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return tok, err
+	}
+	err = json.Unmarshal(buf, &tok)
+	return tok, err
+}
+
+// WriteTokenFile writes the supplied token to filename with permissions
+// that allow only the owner to read it. The file is replaced atomically
+// so that a concurrent ReadTokenFile never sees a partially written
+// token.
+func WriteTokenFile(filename string, tok Token) error {
+	buf, err := json.MarshalIndent(tok, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(buf)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// CachedTokenSource returns a TokenSource that caches tokens in the
+// specified file. A valid token in the file is used in preference to
+// obtaining one from src and any new token obtained from src is written
+// back to the file.
+func CachedTokenSource(filename string, src TokenSource) TokenSource {
+	return &cachedSource{filename: filename, src: src}
+}
+
+type cachedSource struct {
+	filename string
+	src      TokenSource
+	mu       sync.Mutex
+	tok      Token
+}
+
+// Token implements TokenSource.
+func (cs *cachedSource) Token(ctx context.Context) (Token, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.tok.Valid() {
+		return cs.tok, nil
+	}
+	if tok, err := ReadTokenFile(cs.filename); err == nil && tok.Valid() {
+		cs.tok = tok
+		return tok, nil
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Token{}, fmt.Errorf("failed to read cached token: %v: %v", cs.filename, err)
+	}
+	tok, err := cs.src.Token(ctx)
+	if err != nil {
+		return Token{}, err
+	}
+	cs.tok = tok
+	if err := WriteTokenFile(cs.filename, tok); err != nil {
+		return Token{}, fmt.Errorf("failed to write cached token: %v: %v", cs.filename, err)
+	}
+	return tok, nil
+}
@@ -0,0 +1,280 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Extra holds the JSON fields of an object that are not represented by
+// the fields of the Go type it was decoded into. They are written
+// back out when the object is encoded so that it round-trips losslessly.
+type Extra map[string]json.RawMessage
+
+// objectFields is embedded in every type in the protocol model and
+// records the information needed to round-trip the JSON object it was
+// decoded from.
+type objectFields struct {
+	// Extra contains the fields that are not explicitly modeled,
+	// including any modeled fields whose JSON values could not be
+	// decoded into the corresponding Go type.
+	Extra Extra `json:"-"`
+	// keys records the order of the fields in the decoded object.
+	keys []string
+	// raw records the JSON values of the modeled fields as received so
+	// that those that have not been modified can be encoded exactly as
+	// they were received, including zero values, numeric formats and
+	// unix times.
+	raw map[string]json.RawMessage
+}
+
+type fieldInfo struct {
+	index  int
+	name   string
+	isTime bool
+}
+
+var (
+	fieldCache sync.Map // map[reflect.Type][]fieldInfo
+	timeType   = reflect.TypeOf(time.Time{})
+)
+
+func fieldsOf(t reflect.Type) []fieldInfo {
+	if fi, ok := fieldCache.Load(t); ok {
+		return fi.([]fieldInfo)
+	}
+	var fields []fieldInfo
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if len(name) == 0 {
+			name = f.Name
+		}
+		fields = append(fields, fieldInfo{
+			index:  i,
+			name:   name,
+			isTime: f.Type == timeType,
+		})
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+// decodeFields decodes the JSON object in data, returning the value of
+// each field and the order in which the fields occur.
+func decodeFields(data []byte) ([]string, map[string]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if tok == nil {
+		// null decodes to an empty object.
+		return nil, map[string]json.RawMessage{}, nil
+	}
+	if tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("expected a JSON object, not %v", tok)
+	}
+	var keys []string
+	fields := map[string]json.RawMessage{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key := tok.(string)
+		var val json.RawMessage
+		if err := dec.Decode(&val); err != nil {
+			return nil, nil, err
+		}
+		if _, ok := fields[key]; !ok {
+			keys = append(keys, key)
+		}
+		fields[key] = val
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	return keys, fields, nil
+}
+
+// decodeField decodes the JSON value rv of the specified field into fv.
+func decodeField(f fieldInfo, rv json.RawMessage, fv reflect.Value) error {
+	if f.isTime {
+		t, err := decodeTime(rv)
+		fv.Set(reflect.ValueOf(t))
+		return err
+	}
+	return json.Unmarshal(rv, fv.Addr().Interface())
+}
+
+// decodeObject decodes data into the struct pointed to by ptr, which
+// must embed objectFields.
+func decodeObject(data []byte, ptr any, of *objectFields) error {
+	keys, raw, err := decodeFields(data)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(ptr).Elem()
+	of.Extra, of.keys, of.raw = nil, keys, nil
+	for _, f := range fieldsOf(v.Type()) {
+		rv, ok := raw[f.name]
+		if !ok {
+			continue
+		}
+		delete(raw, f.name)
+		fv := v.Field(f.index)
+		if err := decodeField(f, rv, fv); err != nil {
+			// Preserve values that cannot be decoded rather than
+			// failing to decode the entire object.
+			fv.Set(reflect.Zero(fv.Type()))
+			raw[f.name] = rv
+			continue
+		}
+		if of.raw == nil {
+			of.raw = map[string]json.RawMessage{}
+		}
+		of.raw[f.name] = rv
+	}
+	if len(raw) > 0 {
+		of.Extra = raw
+	}
+	return nil
+}
+
+// unmodified returns true if the field's value is that obtained by
+// decoding the JSON value it was decoded from.
+func (of objectFields) unmodified(f fieldInfo, fv reflect.Value) (json.RawMessage, bool) {
+	rv, ok := of.raw[f.name]
+	if !ok {
+		return nil, false
+	}
+	orig := reflect.New(fv.Type()).Elem()
+	if err := decodeField(f, rv, orig); err != nil {
+		return nil, false
+	}
+	return rv, reflect.DeepEqual(orig.Interface(), fv.Interface())
+}
+
+// encodeObject encodes the struct val, which must embed objectFields.
+// Fields that have not been modified since the object was decoded are
+// encoded exactly as they were received and in the order in which they
+// were received, followed by any new fields. Zero valued fields are
+// omitted unless they were present when the object was decoded.
+func encodeObject(val any, of objectFields) ([]byte, error) {
+	v := reflect.ValueOf(val)
+	values := map[string][]byte{}
+	var added []string
+	for _, f := range fieldsOf(v.Type()) {
+		fv := v.Field(f.index)
+		if rv, ok := of.unmodified(f, fv); ok {
+			values[f.name] = rv
+			continue
+		}
+		if fv.IsZero() {
+			continue
+		}
+		var (
+			buf []byte
+			err error
+		)
+		if f.isTime {
+			buf = strconv.AppendInt(nil, fv.Interface().(time.Time).Unix(), 10)
+		} else if buf, err = json.Marshal(fv.Interface()); err != nil {
+			return nil, fmt.Errorf("%v: %v", f.name, err)
+		}
+		values[f.name] = buf
+		if _, ok := of.raw[f.name]; !ok {
+			added = append(added, f.name)
+		}
+	}
+	extra := make([]string, 0, len(of.Extra))
+	for k, rv := range of.Extra {
+		if _, ok := values[k]; ok {
+			// A modeled field whose original value could not be decoded
+			// has since been set.
+			continue
+		}
+		values[k] = rv
+		extra = append(extra, k)
+	}
+	sort.Strings(extra)
+	out := &bytes.Buffer{}
+	out.WriteByte('{')
+	write := func(k string) {
+		val, ok := values[k]
+		if !ok {
+			return
+		}
+		delete(values, k)
+		if out.Len() > 1 {
+			out.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		out.Write(key)
+		out.WriteByte(':')
+		out.Write(val)
+	}
+	for _, keys := range [][]string{of.keys, added, extra} {
+		for _, k := range keys {
+			write(k)
+		}
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+// decodeTime decodes a timestamp represented as a unix time, either
+// as a number or a string, or as an RFC3339 string.
+func decodeTime(rv json.RawMessage) (time.Time, error) {
+	var n json.Number
+	if err := json.Unmarshal(rv, &n); err == nil {
+		return numberToTime(n)
+	}
+	var s string
+	if err := json.Unmarshal(rv, &s); err != nil {
+		return time.Time{}, err
+	}
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := numberToTime(json.Number(s)); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func numberToTime(n json.Number) (time.Time, error) {
+	if len(n) == 0 {
+		return time.Time{}, nil
+	}
+	secs, err := n.Int64()
+	if err != nil {
+		f, ferr := n.Float64()
+		if ferr != nil {
+			return time.Time{}, err
+		}
+		secs = int64(f)
+	}
+	if secs == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(secs, 0), nil
+}
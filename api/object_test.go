@@ -0,0 +1,84 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestProtocolRoundTrip(t *testing.T) {
+	for i, tc := range []string{
+		`{}`,
+		`{"id":1,"title":"t"}`,
+		// Key order is preserved.
+		`{"title":"t","unknown":[1,2],"id":1}`,
+		// Zero values that were present are preserved.
+		`{"id":0,"title":"","public":false,"image":null,"steps":[]}`,
+		// Numeric formats and times are preserved.
+		`{"id":1.0,"created_on":"1600000000","changed_on":1600000000.5,"published_on":"2020-09-13T12:26:40Z"}`,
+		`{"materials":[{"quantity":1.50,"mol_weight":1e3,"extra":{"a":"b"}}]}`,
+		// Values that cannot be decoded are preserved.
+		`{"id":"not-a-number","title":"t"}`,
+		`{"creator":{"name":"a","zz":1,"affiliation":"b"},"steps":[{"id":1,"step":"s","duration":"90"}]}`,
+	} {
+		var p Protocol
+		if err := json.Unmarshal([]byte(tc), &p); err != nil {
+			t.Errorf("%v: %v", i, err)
+			continue
+		}
+		buf, err := json.Marshal(p)
+		if err != nil {
+			t.Errorf("%v: %v", i, err)
+			continue
+		}
+		if got, want := string(buf), tc; got != want {
+			t.Errorf("%v: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestProtocolModified(t *testing.T) {
+	for i, tc := range []struct {
+		input  string
+		modify func(p *Protocol)
+		output string
+	}{
+		{`{"title":"t","id":1.0}`,
+			func(p *Protocol) { p.Title = "u" },
+			`{"title":"u","id":1.0}`},
+		{`{"title":"t","id":1}`,
+			func(p *Protocol) { p.Title = "" },
+			`{"id":1}`},
+		{`{"title":"t","created_on":"1600000000"}`,
+			func(p *Protocol) { p.CreatedOn = time.Unix(1600000001, 0) },
+			`{"title":"t","created_on":1600000001}`},
+		{`{"title":"t","zz":true}`,
+			func(p *Protocol) { p.ID = 2; p.Extra["aa"] = json.RawMessage(`1`) },
+			`{"title":"t","zz":true,"id":2,"aa":1}`},
+		{`{"id":"x"}`,
+			func(p *Protocol) { p.ID = 3 },
+			`{"id":3}`},
+		{`{"steps":[{"step":"a","id":1.0},{"step":"b"}]}`,
+			func(p *Protocol) { p.Steps[1].Step = "c" },
+			`{"steps":[{"step":"a","id":1.0},{"step":"c"}]}`},
+	} {
+		var p Protocol
+		if err := json.Unmarshal([]byte(tc.input), &p); err != nil {
+			t.Errorf("%v: %v", i, err)
+			continue
+		}
+		tc.modify(&p)
+		buf, err := json.Marshal(p)
+		if err != nil {
+			t.Errorf("%v: %v", i, err)
+			continue
+		}
+		if got, want := string(buf), tc.output; got != want {
+			t.Errorf("%v: got %v, want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// PageFetcher is called to fetch the specified page of results.
+type PageFetcher[T any] func(ctx context.Context, pageID int) ([]T, Pagination, error)
+
+// ResumeToken records the progress of a paginated iteration so that it
+// may be resumed, possibly by a different process. It may be serialized
+// as JSON or via its Encode method.
+type ResumeToken struct {
+	NextPage int  `json:"next_page"`
+	Items    int  `json:"items"`
+	Done     bool `json:"done"`
+}
+
+// Encode returns an opaque, URL safe, string representation of the token.
+func (rt ResumeToken) Encode() string {
+	buf, _ := json.Marshal(rt)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// ParseResumeToken parses a token previously created by ResumeToken.Encode.
+func ParseResumeToken(s string) (ResumeToken, error) {
+	var rt ResumeToken
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return rt, fmt.Errorf("invalid resume token: %v", err)
+	}
+	if err := json.Unmarshal(buf, &rt); err != nil {
+		return rt, fmt.Errorf("invalid resume token: %v", err)
+	}
+	return rt, nil
+}
+
+// PaginateOptions represent the options for Paginate.
+type PaginateOptions struct {
+	FirstPage int          // First page to fetch, defaults to 1.
+	LastPage  int          // Last page to fetch, 0 for all pages.
+	MaxItems  int          // Maximum number of items to return, 0 for no limit.
+	PageSize  int          // Used to detect the last page if the server does not report it.
+	Resume    *ResumeToken // If set, resume from this token rather than FirstPage.
+}
+
+// Paginator iterates over the pages, or items, returned by a paginated
+// API endpoint. Iteration is either by page, using NextPage, or by item,
+// using Next, but the two may not be mixed.
+type Paginator[T any] struct {
+	fetch      PageFetcher[T]
+	opts       PaginateOptions
+	next       int
+	items      int
+	done       bool
+	err        error
+	pageID     int
+	page       []T
+	pagination Pagination
+	idx        int
+}
+
+// Paginate returns a Paginator that uses fetch to obtain each page.
+func Paginate[T any](fetch PageFetcher[T], opts PaginateOptions) *Paginator[T] {
+	p := &Paginator[T]{fetch: fetch, opts: opts, next: opts.FirstPage}
+	if p.next == 0 {
+		p.next = 1
+	}
+	if rt := opts.Resume; rt != nil && (rt.NextPage > 0 || rt.Done) {
+		p.next, p.items, p.done = rt.NextPage, rt.Items, rt.Done
+	}
+	return p
+}
+
+// NextPage fetches the next page, returning false when there are no
+// more pages or an error is encountered.
+func (p *Paginator[T]) NextPage(ctx context.Context) bool {
+	if p.done || p.err != nil {
+		return false
+	}
+	if err := ctx.Err(); err != nil {
+		p.err = err
+		return false
+	}
+	if p.opts.MaxItems > 0 && p.items >= p.opts.MaxItems {
+		p.done = true
+		return false
+	}
+	items, pagination, err := p.fetch(ctx, p.next)
+	if err != nil {
+		p.err = err
+		return false
+	}
+	p.pageID, p.pagination, p.idx = p.next, pagination, -1
+	if max := p.opts.MaxItems; max > 0 && p.items+len(items) >= max {
+		items = items[:max-p.items]
+		p.done = true
+	}
+	p.page = items
+	p.items += len(items)
+	next, done, err := pagination.NextPageID(p.pageID, len(items), p.opts.PageSize)
+	if err != nil {
+		p.err = err
+		return false
+	}
+	if p.opts.LastPage > 0 && p.pageID >= p.opts.LastPage {
+		done = true
+	}
+	p.next = next
+	p.done = p.done || done
+	return true
+}
+
+// Next advances to the next item, fetching a new page as required.
+func (p *Paginator[T]) Next(ctx context.Context) bool {
+	for {
+		if p.page != nil && p.idx+1 < len(p.page) {
+			p.idx++
+			return true
+		}
+		if !p.NextPage(ctx) {
+			return false
+		}
+	}
+}
+
+// Item returns the current item.
+func (p *Paginator[T]) Item() T {
+	return p.page[p.idx]
+}
+
+// Page returns the items in the current page.
+func (p *Paginator[T]) Page() []T {
+	return p.page
+}
+
+// PageID returns the id of the current page.
+func (p *Paginator[T]) PageID() int {
+	return p.pageID
+}
+
+// Pagination returns the pagination information returned by the server
+// for the current page.
+func (p *Paginator[T]) Pagination() Pagination {
+	return p.pagination
+}
+
+// Err returns any error encountered during iteration.
+func (p *Paginator[T]) Err() error {
+	return p.err
+}
+
+// ResumeToken returns a token that can be used to resume iteration
+// from the page following the current one.
+func (p *Paginator[T]) ResumeToken() ResumeToken {
+	return ResumeToken{NextPage: p.next, Items: p.items, Done: p.done}
+}
+
+// NextPageID determines the id of the page following current, which
+// contained n items. The next_page URL is used if present, otherwise
+// the page following current is assumed. Servers that report zero total
+// pages are assumed to have no more pages once an empty, or partial,
+// page, as determined by pageSize, is returned.
+func (p Pagination) NextPageID(current, n, pageSize int) (next int, done bool, err error) {
+	if p.TotalPages > 0 && p.CurrentPage >= p.TotalPages {
+		return 0, true, nil
+	}
+	if len(p.NextPage) > 0 {
+		u, err := url.Parse(p.NextPage)
+		if err != nil {
+			return 0, false, err
+		}
+		if np := u.Query().Get("page_id"); len(np) > 0 {
+			npi, err := strconv.Atoi(np)
+			if err != nil {
+				return 0, false, fmt.Errorf("failed to parse %q: %v", np, err)
+			}
+			return npi, false, nil
+		}
+	}
+	if p.TotalPages == 0 && (n == 0 || (pageSize > 0 && n < pageSize)) {
+		return 0, true, nil
+	}
+	if p.CurrentPage > 0 {
+		return int(p.CurrentPage) + 1, false, nil
+	}
+	return current + 1, false, nil
+}
+
+// Done returns true if p is for the last page, assuming that the page
+// was full. It is retained for compatibility, new code should use
+// NextPageID or Paginate.
+func (p Pagination) Done() bool {
+	_, _, done, _ := p.PageInfo()
+	return done
+}
+
+// PageInfo returns the id of the next page, the total number of pages
+// and whether p is for the last page, assuming that the page was full.
+// It is retained for compatibility, new code should use NextPageID or
+// Paginate.
+func (p Pagination) PageInfo() (next, total int, done bool, err error) {
+	size := int(p.PageSize)
+	next, done, err = p.NextPageID(int(p.CurrentPage), size, size)
+	return next, int(p.TotalPages), done, err
+}
+
+// PaginateProtocols returns a Paginator over the protocols returned
+// by ListProtocols for the supplied query parameters. The page_id
+// parameter is set for each page.
+func (c *Client) PaginateProtocols(params url.Values, opts PaginateOptions) *Paginator[json.RawMessage] {
+	v := url.Values{}
+	for k, vals := range params {
+		v[k] = vals
+	}
+	return Paginate(func(ctx context.Context, pageID int) ([]json.RawMessage, Pagination, error) {
+		v.Set("page_id", strconv.Itoa(pageID))
+		resp, _, err := c.ListProtocols(ctx, v)
+		return resp.Items, resp.Pagination, err
+	}, opts)
+}
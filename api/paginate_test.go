@@ -0,0 +1,129 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestNextPageID(t *testing.T) {
+	for i, tc := range []struct {
+		pagination     Pagination
+		current, n, sz int
+		next           int
+		done           bool
+	}{
+		{Pagination{CurrentPage: 3, TotalPages: 3}, 3, 10, 10, 0, true},
+		{Pagination{CurrentPage: 4, TotalPages: 3}, 4, 10, 10, 0, true},
+		{Pagination{CurrentPage: 1, TotalPages: 3, NextPage: "https://x/v3/protocols?page_id=7&page_size=10"}, 1, 10, 10, 7, false},
+		{Pagination{CurrentPage: 1, TotalPages: 3, NextPage: "https://x/v3/protocols?page_size=10"}, 1, 10, 10, 2, false},
+		{Pagination{CurrentPage: 2, TotalPages: 3}, 2, 10, 10, 3, false},
+		// The current page is used if the server does not report it.
+		{Pagination{TotalPages: 3}, 5, 10, 10, 6, false},
+		// Servers that do not report the number of pages.
+		{Pagination{}, 1, 10, 10, 2, false},
+		{Pagination{}, 1, 9, 10, 0, true},
+		{Pagination{}, 1, 0, 0, 0, true},
+		{Pagination{}, 1, 9, 0, 2, false},
+	} {
+		next, done, err := tc.pagination.NextPageID(tc.current, tc.n, tc.sz)
+		if err != nil {
+			t.Errorf("%v: %v", i, err)
+			continue
+		}
+		if got, want := next, tc.next; got != want {
+			t.Errorf("%v: next: got %v, want %v", i, got, want)
+		}
+		if got, want := done, tc.done; got != want {
+			t.Errorf("%v: done: got %v, want %v", i, got, want)
+		}
+	}
+	if _, _, err := (Pagination{NextPage: "https://x/?page_id=x"}).NextPageID(1, 1, 1); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestPageInfo(t *testing.T) {
+	p := Pagination{CurrentPage: 1, TotalPages: 2, PageSize: 10, NextPage: "https://x/?page_id=2"}
+	next, total, done, err := p.PageInfo()
+	if err != nil || next != 2 || total != 2 || done || p.Done() {
+		t.Errorf("got %v, %v, %v, %v, %v", next, total, done, err, p.Done())
+	}
+	p = Pagination{CurrentPage: 2, TotalPages: 2, PageSize: 10}
+	if _, _, done, err := p.PageInfo(); err != nil || !done || !p.Done() {
+		t.Errorf("got %v, %v, %v", done, err, p.Done())
+	}
+}
+
+func TestResumeToken(t *testing.T) {
+	for i, tc := range []ResumeToken{
+		{},
+		{NextPage: 3, Items: 20},
+		{NextPage: 0, Items: 45, Done: true},
+	} {
+		rt, err := ParseResumeToken(tc.Encode())
+		if err != nil {
+			t.Errorf("%v: %v", i, err)
+			continue
+		}
+		if got, want := rt, tc; got != want {
+			t.Errorf("%v: got %v, want %v", i, got, want)
+		}
+	}
+	for i, tc := range []string{"!", "bm90IGpzb24"} {
+		if _, err := ParseResumeToken(tc); err == nil {
+			t.Errorf("%v: %v: expected an error", i, tc)
+		}
+	}
+}
+
+// pages returns a PageFetcher for a server with the specified number of
+// items and page size that does not report the number of pages.
+func pages(items, size int, fetched *[]int) PageFetcher[int] {
+	return func(ctx context.Context, pageID int) ([]int, Pagination, error) {
+		*fetched = append(*fetched, pageID)
+		var page []int
+		for i := (pageID - 1) * size; i < pageID*size && i < items; i++ {
+			page = append(page, i)
+		}
+		return page, Pagination{CurrentPage: int64(pageID)}, nil
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	ctx := context.Background()
+	for i, tc := range []struct {
+		opts    PaginateOptions
+		items   []int
+		fetched []int
+		resume  ResumeToken
+	}{
+		{PaginateOptions{PageSize: 3}, []int{0, 1, 2, 3, 4, 5, 6}, []int{1, 2, 3}, ResumeToken{NextPage: 0, Items: 7, Done: true}},
+		{PaginateOptions{PageSize: 3, FirstPage: 2, LastPage: 2}, []int{3, 4, 5}, []int{2}, ResumeToken{NextPage: 3, Items: 3, Done: true}},
+		{PaginateOptions{PageSize: 3, MaxItems: 4}, []int{0, 1, 2, 3}, []int{1, 2}, ResumeToken{NextPage: 0, Items: 4, Done: true}},
+		{PaginateOptions{PageSize: 3, Resume: &ResumeToken{NextPage: 3, Items: 6}}, []int{6}, []int{3}, ResumeToken{NextPage: 0, Items: 7, Done: true}},
+		{PaginateOptions{PageSize: 3, Resume: &ResumeToken{Items: 7, Done: true}}, nil, nil, ResumeToken{Items: 7, Done: true}},
+	} {
+		var fetched, items []int
+		pg := Paginate(pages(7, 3, &fetched), tc.opts)
+		for pg.Next(ctx) {
+			items = append(items, pg.Item())
+		}
+		if err := pg.Err(); err != nil {
+			t.Errorf("%v: %v", i, err)
+		}
+		if got, want := items, tc.items; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: items: got %v, want %v", i, got, want)
+		}
+		if got, want := fetched, tc.fetched; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: fetched: got %v, want %v", i, got, want)
+		}
+		if got, want := pg.ResumeToken(), tc.resume; got != want {
+			t.Errorf("%v: resume: got %v, want %v", i, got, want)
+		}
+	}
+}
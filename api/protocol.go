@@ -0,0 +1,286 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"strings"
+	"time"
+)
+
+// Protocol represents a protocol as returned by the v4 API. Fields that
+// contain rich text, such as Description or Step.Step, are encoded as
+// Draft.js JSON documents. Timestamps are converted to time.Time from
+// the unix times used by the API. Fields that are not modeled explicitly
+// are preserved in Extra, and fields that are not modified are encoded
+// exactly as, and in the order, they were received, so that a Protocol
+// round-trips losslessly.
+type Protocol struct {
+	objectFields
+	ID            int64         `json:"id"`
+	URI           string        `json:"uri"`
+	URL           string        `json:"url"`
+	Title         string        `json:"title"`
+	TitleHTML     string        `json:"title_html"`
+	Image         *Image        `json:"image"`
+	DOI           string        `json:"doi"`
+	VersionID     int           `json:"version_id"`
+	VersionURI    string        `json:"version_uri"`
+	Versions      []ProtocolRef `json:"versions"`
+	ForkFrom      *ProtocolRef  `json:"fork_from"`
+	ForksCount    int           `json:"forks_count"`
+	Creator       Author        `json:"creator"`
+	Authors       []Author      `json:"authors"`
+	Description   string        `json:"description"`
+	Guidelines    string        `json:"guidelines"`
+	Warning       string        `json:"warning"`
+	BeforeStart   string        `json:"before_start"`
+	MaterialsText string        `json:"materials_text"`
+	Materials     []Material    `json:"materials"`
+	Steps         []Step        `json:"steps"`
+	Keywords      string        `json:"keywords"`
+	License       *License      `json:"license"`
+	Public        bool          `json:"public"`
+	Stats         *Stats        `json:"stats"`
+	CreatedOn     time.Time     `json:"created_on"`
+	PublishedOn   time.Time     `json:"published_on"`
+	ChangedOn     time.Time     `json:"changed_on"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *Protocol) UnmarshalJSON(data []byte) error {
+	return decodeObject(data, p, &p.objectFields)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p Protocol) MarshalJSON() ([]byte, error) {
+	return encodeObject(p, p.objectFields)
+}
+
+// KeywordList returns the protocol's keywords as a slice.
+func (p Protocol) KeywordList() []string {
+	var kw []string
+	for _, k := range strings.Split(p.Keywords, ",") {
+		if k = strings.TrimSpace(k); len(k) > 0 {
+			kw = append(kw, k)
+		}
+	}
+	return kw
+}
+
+// LastModified returns the most recent of the protocol's creation,
+// publication and change times.
+func (p Protocol) LastModified() time.Time {
+	t := p.CreatedOn
+	for _, c := range []time.Time{p.PublishedOn, p.ChangedOn} {
+		if c.After(t) {
+			t = c
+		}
+	}
+	return t
+}
+
+// ProtocolRef refers to another version, or fork, of a protocol.
+type ProtocolRef struct {
+	objectFields
+	ID          int64     `json:"id"`
+	URI         string    `json:"uri"`
+	Title       string    `json:"title"`
+	VersionID   int       `json:"version_id"`
+	DOI         string    `json:"doi"`
+	CreatedOn   time.Time `json:"created_on"`
+	PublishedOn time.Time `json:"published_on"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (r *ProtocolRef) UnmarshalJSON(data []byte) error {
+	return decodeObject(data, r, &r.objectFields)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (r ProtocolRef) MarshalJSON() ([]byte, error) {
+	return encodeObject(r, r.objectFields)
+}
+
+// Image represents an image hosted by protocols.io.
+type Image struct {
+	objectFields
+	Source      string `json:"source"`
+	Placeholder string `json:"placeholder"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (i *Image) UnmarshalJSON(data []byte) error {
+	return decodeObject(data, i, &i.objectFields)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (i Image) MarshalJSON() ([]byte, error) {
+	return encodeObject(i, i.objectFields)
+}
+
+// Affiliation represents an author's institutional affiliation.
+type Affiliation struct {
+	objectFields
+	Affiliation string `json:"affiliation"`
+	URL         string `json:"url"`
+	Department  string `json:"department"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (a *Affiliation) UnmarshalJSON(data []byte) error {
+	return decodeObject(data, a, &a.objectFields)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (a Affiliation) MarshalJSON() ([]byte, error) {
+	return encodeObject(a, a.objectFields)
+}
+
+// Author represents the creator or an author of a protocol.
+type Author struct {
+	objectFields
+	Name         string        `json:"name"`
+	Username     string        `json:"username"`
+	Affiliation  string        `json:"affiliation"`
+	Affiliations []Affiliation `json:"affiliations"`
+	ORCID        string        `json:"orcid"`
+	Link         string        `json:"link"`
+	Image        *Image        `json:"image"`
+	Note         string        `json:"note"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (a *Author) UnmarshalJSON(data []byte) error {
+	return decodeObject(data, a, &a.objectFields)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (a Author) MarshalJSON() ([]byte, error) {
+	return encodeObject(a, a.objectFields)
+}
+
+// ORCIDURL returns the author's ORCID as a URL, or an empty string
+// if the author does not have an ORCID.
+func (a Author) ORCIDURL() string {
+	switch {
+	case len(a.ORCID) == 0:
+		return ""
+	case strings.HasPrefix(a.ORCID, "http"):
+		return a.ORCID
+	}
+	return "https://orcid.org/" + a.ORCID
+}
+
+// Step represents a single step in a protocol. Steps may be grouped
+// into sections, in which case Section contains the section title.
+type Step struct {
+	objectFields
+	ID           int64     `json:"id"`
+	GUID         string    `json:"guid"`
+	PreviousID   int64     `json:"previous_id"`
+	PreviousGUID string    `json:"previous_guid"`
+	Number       string    `json:"number"`
+	Section      string    `json:"section"`
+	SectionColor string    `json:"section_color"`
+	Step         string    `json:"step"`
+	Duration     int       `json:"duration"` // Seconds.
+	CreatedOn    time.Time `json:"created_on"`
+	ChangedOn    time.Time `json:"changed_on"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Step) UnmarshalJSON(data []byte) error {
+	return decodeObject(data, s, &s.objectFields)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s Step) MarshalJSON() ([]byte, error) {
+	return encodeObject(s, s.objectFields)
+}
+
+// Material represents a reagent, piece of equipment or other material
+// required by a protocol.
+type Material struct {
+	objectFields
+	ID        int64   `json:"id"`
+	Name      string  `json:"name"`
+	Quantity  float64 `json:"quantity"`
+	Unit      string  `json:"unit"`
+	SKU       string  `json:"sku"`
+	CASNumber string  `json:"cas_number"`
+	RRID      string  `json:"rrid"`
+	MolWeight float64 `json:"mol_weight"`
+	LinFor    string  `json:"linfor"`
+	URL       string  `json:"url"`
+	Vendor    *Vendor `json:"vendor"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *Material) UnmarshalJSON(data []byte) error {
+	return decodeObject(data, m, &m.objectFields)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m Material) MarshalJSON() ([]byte, error) {
+	return encodeObject(m, m.objectFields)
+}
+
+// Vendor represents the supplier of a material.
+type Vendor struct {
+	objectFields
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *Vendor) UnmarshalJSON(data []byte) error {
+	return decodeObject(data, v, &v.objectFields)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (v Vendor) MarshalJSON() ([]byte, error) {
+	return encodeObject(v, v.objectFields)
+}
+
+// License represents the license that a protocol is published under.
+type License struct {
+	objectFields
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Link  string `json:"link"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (l *License) UnmarshalJSON(data []byte) error {
+	return decodeObject(data, l, &l.objectFields)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (l License) MarshalJSON() ([]byte, error) {
+	return encodeObject(l, l.objectFields)
+}
+
+// Stats contains usage statistics for a protocol.
+type Stats struct {
+	objectFields
+	Views     int `json:"number_of_views"`
+	Steps     int `json:"number_of_steps"`
+	Bookmarks int `json:"number_of_bookmarks"`
+	Comments  int `json:"number_of_comments"`
+	Exports   int `json:"number_of_exports"`
+	Runs      int `json:"number_of_runs"`
+	Votes     int `json:"number_of_votes"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Stats) UnmarshalJSON(data []byte) error {
+	return decodeObject(data, s, &s.objectFields)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s Stats) MarshalJSON() ([]byte, error) {
+	return encodeObject(s, s.objectFields)
+}
@@ -0,0 +1,159 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// RateLimiter is used to limit the rate at which requests are issued.
+// A single RateLimiter may be shared by multiple goroutines and clients.
+type RateLimiter interface {
+	// Wait blocks until the next request may be issued or the context
+	// is canceled.
+	Wait(ctx context.Context) error
+}
+
+// NewRateLimiter returns a RateLimiter that allows at most the specified
+// number of requests per minute, evenly spaced. A non-positive value
+// results in no limit being applied.
+func NewRateLimiter(perMinute int) RateLimiter {
+	if perMinute <= 0 {
+		return unlimited{}
+	}
+	return NewTokenBucket(perMinute, 1)
+}
+
+type unlimited struct{}
+
+func (unlimited) Wait(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// TokenBucket is a RateLimiter that implements the token bucket
+// algorithm: tokens are added at a fixed rate up to a maximum burst
+// size and every request consumes a token, waiting for one to become
+// available if necessary. Waiting requests are served in order.
+type TokenBucket struct {
+	rate      float64 // tokens per second.
+	burst     float64
+	stateFile string
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// TokenBucketOption represents an option to NewTokenBucket.
+type TokenBucketOption func(tb *TokenBucket)
+
+// WithStateFile specifies a file in which the state of the token
+// bucket is persisted so that it is shared by successive processes,
+// for example, back-to-back invocations of a command line tool. Any
+// existing state in the file is read when the bucket is created and the
+// file is updated every time a token is consumed. Failures to read or
+// write the file are ignored and the bucket starts full.
+func WithStateFile(filename string) TokenBucketOption {
+	return func(tb *TokenBucket) {
+		tb.stateFile = filename
+	}
+}
+
+// NewTokenBucket returns a TokenBucket that allows perMinute requests
+// per minute with bursts of up to burst requests.
+func NewTokenBucket(perMinute, burst int, opts ...TokenBucketOption) *TokenBucket {
+	if perMinute < 1 {
+		perMinute = 1
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	tb := &TokenBucket{
+		rate:   float64(perMinute) / 60,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+	for _, fn := range opts {
+		fn(tb)
+	}
+	tb.load()
+	return tb
+}
+
+type tokenBucketState struct {
+	Tokens float64   `json:"tokens"`
+	Last   time.Time `json:"last"`
+}
+
+func (tb *TokenBucket) load() {
+	if len(tb.stateFile) == 0 {
+		return
+	}
+	buf, err := os.ReadFile(tb.stateFile)
+	if err != nil {
+		return
+	}
+	var state tokenBucketState
+	if json.Unmarshal(buf, &state) != nil || state.Last.After(time.Now()) {
+		return
+	}
+	tb.tokens, tb.last = state.Tokens, state.Last
+}
+
+func (tb *TokenBucket) save() {
+	if len(tb.stateFile) == 0 {
+		return
+	}
+	buf, err := json.Marshal(tokenBucketState{Tokens: tb.tokens, Last: tb.last})
+	if err != nil {
+		return
+	}
+	tmp := tb.stateFile + ".tmp"
+	if os.WriteFile(tmp, buf, 0600) == nil {
+		os.Rename(tmp, tb.stateFile) //nolint:errcheck
+	}
+}
+
+func (tb *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(tb.last); elapsed > 0 {
+		tb.tokens += elapsed.Seconds() * tb.rate
+		if tb.tokens > tb.burst {
+			tb.tokens = tb.burst
+		}
+	}
+	tb.last = now
+}
+
+// Wait implements RateLimiter.
+func (tb *TokenBucket) Wait(ctx context.Context) error {
+	tb.mu.Lock()
+	tb.refill(time.Now())
+	tb.tokens--
+	var delay time.Duration
+	if tb.tokens < 0 {
+		delay = time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+	}
+	tb.save()
+	tb.mu.Unlock()
+	if delay == 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		// Return the unused token.
+		tb.mu.Lock()
+		tb.tokens++
+		tb.save()
+		tb.mu.Unlock()
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
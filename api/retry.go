@@ -0,0 +1,203 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy determines if and when a failed request should be retried.
+type RetryPolicy interface {
+	// Retry is called with the number of attempts made so far (starting
+	// at 1) and either the response, which will have a non-2xx status
+	// code, or the error encountered making the request. It returns the
+	// delay to wait before retrying and true if the request should be
+	// retried.
+	Retry(attempt int, res *http.Response, err error) (time.Duration, bool)
+}
+
+// RetryEvent describes a single retry.
+type RetryEvent struct {
+	URL        string
+	Attempt    int
+	StatusCode int   // Set if the request failed with an http status code.
+	Err        error // Set if the request failed with an error.
+	Delay      time.Duration
+}
+
+// RetryHook is called before each retry is attempted.
+type RetryHook func(RetryEvent)
+
+// BackoffRule specifies an exponential backoff whereby the delay starts
+// at InitialDelay and doubles on every retry. Retries cease when the
+// delay would exceed MaxDelay or, if MaxAttempts is non-zero, once
+// MaxAttempts have been made.
+type BackoffRule struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	MaxAttempts  int
+}
+
+func (br BackoffRule) delay(attempt int) (time.Duration, bool) {
+	if br.MaxAttempts > 0 && attempt >= br.MaxAttempts {
+		return 0, false
+	}
+	delay := br.InitialDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= br.MaxDelay {
+			return 0, false
+		}
+	}
+	return delay, true
+}
+
+// ExponentialBackoff is a RetryPolicy that implements jittered,
+// exponential backoff with per-http-status rules. Delays requested by
+// the server via the Retry-After or X-RateLimit-* headers take
+// precedence over the computed delay, but the request is not retried
+// if the server's delay exceeds the rule's MaxDelay.
+type ExponentialBackoff struct {
+	// Status contains the rules for each retryable http status code,
+	// status codes without a rule are not retried.
+	Status map[int]BackoffRule
+	// Transient is the rule for transient errors such as connection
+	// resets or timeouts.
+	Transient BackoffRule
+	// Jitter is the fraction, in the range [0, 1), of the delay
+	// by which it is randomly increased or decreased.
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns the RetryPolicy used by default. Rate
+// limited requests are retried starting with a one minute delay up to
+// a maximum of 16 minutes, server errors and transient failures are
+// retried more aggressively.
+func DefaultRetryPolicy() *ExponentialBackoff {
+	serverErrors := BackoffRule{
+		InitialDelay: time.Second * 2,
+		MaxDelay:     time.Minute * 2,
+		MaxAttempts:  6,
+	}
+	return &ExponentialBackoff{
+		Status: map[int]BackoffRule{
+			http.StatusTooManyRequests: {
+				InitialDelay: time.Minute,
+				MaxDelay:     time.Minute * 16,
+			},
+			http.StatusInternalServerError: serverErrors,
+			http.StatusBadGateway:          serverErrors,
+			http.StatusServiceUnavailable:  serverErrors,
+			http.StatusGatewayTimeout:      serverErrors,
+		},
+		Transient: serverErrors,
+		Jitter:    0.2,
+	}
+}
+
+// Retry implements RetryPolicy.
+func (eb *ExponentialBackoff) Retry(attempt int, res *http.Response, err error) (time.Duration, bool) {
+	var rule BackoffRule
+	if err != nil {
+		if !IsTransient(err) {
+			return 0, false
+		}
+		rule = eb.Transient
+	} else {
+		r, ok := eb.Status[res.StatusCode]
+		if !ok {
+			return 0, false
+		}
+		rule = r
+	}
+	delay, ok := rule.delay(attempt)
+	if !ok {
+		return 0, false
+	}
+	if res != nil {
+		if d, ok := ServerDelay(res.Header, time.Now()); ok {
+			if d > rule.MaxDelay {
+				return 0, false
+			}
+			return d, true
+		}
+	}
+	return eb.jitter(delay), true
+}
+
+func (eb *ExponentialBackoff) jitter(delay time.Duration) time.Duration {
+	if eb.Jitter <= 0 {
+		return delay
+	}
+	j := (rand.Float64()*2 - 1) * eb.Jitter // #nosec G404
+	return delay + time.Duration(float64(delay)*j)
+}
+
+// IsTransient returns true if err is likely to be a transient
+// network failure, such as a connection reset or timeout.
+func IsTransient(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// ServerDelay returns the delay requested by the server via either the
+// Retry-After header, or the X-RateLimit-Remaining and X-RateLimit-Reset
+// headers. Retry-After may be specified in seconds or as an http date.
+// X-RateLimit-Reset may be specified as either a number of seconds or
+// as a unix time.
+func ServerDelay(h http.Header, now time.Time) (time.Duration, bool) {
+	if ra := h.Get("Retry-After"); len(ra) > 0 {
+		if secs, err := strconv.ParseInt(ra, 10, 64); err == nil && secs >= 0 {
+			return seconds(secs), true
+		}
+		if t, err := http.ParseTime(ra); err == nil {
+			return clampDelay(t.Sub(now)), true
+		}
+	}
+	if h.Get("X-RateLimit-Remaining") != "0" {
+		return 0, false
+	}
+	reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil || reset < 0 {
+		return 0, false
+	}
+	// Values larger than a year's worth of seconds are assumed to
+	// be unix times.
+	if reset > 365*24*60*60 {
+		return clampDelay(time.Unix(reset, 0).Sub(now)), true
+	}
+	return seconds(reset), true
+}
+
+// seconds returns secs as a Duration, limited to the maximum Duration
+// rather than overflowing.
+func seconds(secs int64) time.Duration {
+	if secs > math.MaxInt64/int64(time.Second) {
+		return math.MaxInt64
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func clampDelay(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func header(kv ...string) http.Header {
+	h := http.Header{}
+	for i := 0; i < len(kv); i += 2 {
+		h.Set(kv[i], kv[i+1])
+	}
+	return h
+}
+
+func TestServerDelay(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, tc := range []struct {
+		header http.Header
+		delay  time.Duration
+		ok     bool
+	}{
+		{header(), 0, false},
+		{header("Retry-After", "120"), 2 * time.Minute, true},
+		{header("Retry-After", "0"), 0, true},
+		{header("Retry-After", now.Add(time.Minute).Format(http.TimeFormat)), time.Minute, true},
+		{header("Retry-After", now.Add(-time.Minute).Format(http.TimeFormat)), 0, true},
+		{header("Retry-After", "99999999999999999"), math.MaxInt64, true},
+		{header("Retry-After", "-1"), 0, false},
+		{header("Retry-After", "soon"), 0, false},
+		{header("X-RateLimit-Remaining", "0", "X-RateLimit-Reset", "30"), 30 * time.Second, true},
+		{header("X-RateLimit-Remaining", "0", "X-RateLimit-Reset", fmt.Sprint(now.Add(time.Hour).Unix())), time.Hour, true},
+		{header("X-RateLimit-Remaining", "0", "X-RateLimit-Reset", fmt.Sprint(now.Add(-time.Hour).Unix())), 0, true},
+		{header("X-RateLimit-Remaining", "1", "X-RateLimit-Reset", "30"), 0, false},
+		{header("X-RateLimit-Remaining", "0", "X-RateLimit-Reset", "x"), 0, false},
+		{header("X-RateLimit-Remaining", "0"), 0, false},
+		// Retry-After takes precedence.
+		{header("Retry-After", "5", "X-RateLimit-Remaining", "0", "X-RateLimit-Reset", "30"), 5 * time.Second, true},
+	} {
+		delay, ok := ServerDelay(tc.header, now)
+		if got, want := delay, tc.delay; got != want {
+			t.Errorf("%v: %v: got %v, want %v", i, tc.header, got, want)
+		}
+		if got, want := ok, tc.ok; got != want {
+			t.Errorf("%v: %v: got %v, want %v", i, tc.header, got, want)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	rule := BackoffRule{InitialDelay: time.Second, MaxDelay: 10 * time.Second, MaxAttempts: 10}
+	eb := &ExponentialBackoff{
+		Status:    map[int]BackoffRule{http.StatusTooManyRequests: rule},
+		Transient: rule,
+	}
+	response := func(status int, h http.Header) *http.Response {
+		return &http.Response{StatusCode: status, Header: h}
+	}
+	for i, tc := range []struct {
+		attempt int
+		res     *http.Response
+		err     error
+		delay   time.Duration
+		retry   bool
+	}{
+		{1, response(http.StatusTooManyRequests, header()), nil, time.Second, true},
+		{2, response(http.StatusTooManyRequests, header()), nil, 2 * time.Second, true},
+		{4, response(http.StatusTooManyRequests, header()), nil, 8 * time.Second, true},
+		{5, response(http.StatusTooManyRequests, header()), nil, 0, false},
+		{1, response(http.StatusNotFound, header()), nil, 0, false},
+		{1, response(http.StatusTooManyRequests, header("Retry-After", "7")), nil, 7 * time.Second, true},
+		// Server delays that exceed the rule's maximum are not retried.
+		{1, response(http.StatusTooManyRequests, header("Retry-After", "11")), nil, 0, false},
+		{1, response(http.StatusTooManyRequests, header("Retry-After", "99999999999999999")), nil, 0, false},
+		{1, nil, syscall.ECONNRESET, time.Second, true},
+		{1, nil, fmt.Errorf("read: %w", io.ErrUnexpectedEOF), time.Second, true},
+		{1, nil, errors.New("permanent"), 0, false},
+	} {
+		delay, retry := eb.Retry(tc.attempt, tc.res, tc.err)
+		if got, want := delay, tc.delay; got != want {
+			t.Errorf("%v: got %v, want %v", i, got, want)
+		}
+		if got, want := retry, tc.retry; got != want {
+			t.Errorf("%v: got %v, want %v", i, got, want)
+		}
+	}
+	rule.MaxAttempts = 2
+	if _, ok := rule.delay(2); ok {
+		t.Errorf("expected no more attempts")
+	}
+}
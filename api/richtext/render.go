@@ -0,0 +1,436 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package richtext
+
+import (
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// inlineRenderer is implemented by each output format to render the
+// text, styles and entities within a block.
+type inlineRenderer interface {
+	escape(text string) string
+	styled(text string, styles []string) string
+	link(text, url string) string
+	image(src, alt string) string
+	table(rows [][]string) string
+}
+
+func (d *Document) inline(b Block, r inlineRenderer) string {
+	var out strings.Builder
+	for _, s := range b.spans() {
+		var content strings.Builder
+		for _, run := range s.runs {
+			content.WriteString(r.styled(r.escape(run.text), run.styles))
+		}
+		e, kind := d.entityKind(s.entity)
+		switch kind {
+		case "link":
+			if u := e.LinkURL(); len(u) > 0 {
+				out.WriteString(r.link(content.String(), u))
+				continue
+			}
+		case "image":
+			if src := e.ImageSource(); len(src) > 0 {
+				out.WriteString(r.image(src, e.ImageAlt()))
+				continue
+			}
+		case "table":
+			if rows := e.TableRows(); len(rows) > 0 {
+				out.WriteString(r.table(rows))
+				continue
+			}
+		}
+		out.WriteString(content.String())
+	}
+	return out.String()
+}
+
+// listCounters tracks the item numbers for nested ordered lists.
+type listCounters []int
+
+func (lc *listCounters) next(depth int) int {
+	for len(*lc) <= depth {
+		*lc = append(*lc, 0)
+	}
+	*lc = (*lc)[:depth+1]
+	(*lc)[depth]++
+	return (*lc)[depth]
+}
+
+func (lc *listCounters) reset() {
+	*lc = (*lc)[:0]
+}
+
+// splitSpace returns the leading and trailing whitespace of text
+// separately from the remaining text so that markup can be applied
+// to just the non-whitespace text.
+func splitSpace(text string) (leading, trimmed, trailing string) {
+	trimmed = strings.TrimLeft(text, " \t\n")
+	leading = text[:len(text)-len(trimmed)]
+	t := strings.TrimRight(trimmed, " \t\n")
+	trailing = trimmed[len(t):]
+	return leading, t, trailing
+}
+
+type textRenderer struct{}
+
+func (textRenderer) escape(text string) string                  { return text }
+func (textRenderer) styled(text string, styles []string) string { return text }
+func (textRenderer) link(text, u string) string {
+	if len(strings.TrimSpace(text)) == 0 || text == u {
+		return u
+	}
+	return text + " (" + u + ")"
+}
+func (textRenderer) image(src, alt string) string {
+	if len(alt) > 0 {
+		return "[image: " + alt + " " + src + "]"
+	}
+	return "[image: " + src + "]"
+}
+func (textRenderer) table(rows [][]string) string {
+	var out strings.Builder
+	for i, row := range rows {
+		if i > 0 {
+			out.WriteByte('\n')
+		}
+		out.WriteString(strings.Join(row, "\t"))
+	}
+	return out.String()
+}
+
+// Text renders the document as plain text.
+func (d *Document) Text() string {
+	var (
+		out      strings.Builder
+		counters listCounters
+	)
+	for _, b := range d.Blocks {
+		content := d.inline(b, textRenderer{})
+		if ordered, ok := b.isList(); ok {
+			out.WriteString(strings.Repeat("  ", b.Depth))
+			if ordered {
+				out.WriteString(strconv.Itoa(counters.next(b.Depth)) + ". ")
+			} else {
+				out.WriteString("- ")
+			}
+		} else {
+			counters.reset()
+		}
+		out.WriteString(content)
+		out.WriteByte('\n')
+	}
+	return strings.TrimRight(out.String(), " \n")
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`,
+	"[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`,
+	"\n", "  \n")
+
+// EscapeMarkdown escapes plain text, such as a title, for inclusion in
+// a Markdown document.
+func EscapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+type markdownRenderer struct{}
+
+func (markdownRenderer) escape(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+func (markdownRenderer) styled(text string, styles []string) string {
+	leading, t, trailing := splitSpace(text)
+	if len(t) == 0 {
+		return text
+	}
+	for _, s := range styles {
+		switch s {
+		case "BOLD":
+			t = "**" + t + "**"
+		case "ITALIC":
+			t = "_" + t + "_"
+		case "STRIKETHROUGH":
+			t = "~~" + t + "~~"
+		case "CODE":
+			t = "`" + t + "`"
+		case "SUPERSCRIPT":
+			t = "<sup>" + t + "</sup>"
+		case "SUBSCRIPT":
+			t = "<sub>" + t + "</sub>"
+		}
+	}
+	return leading + t + trailing
+}
+
+var markdownURLEscaper = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29")
+
+func (markdownRenderer) link(text, u string) string {
+	if !SafeURL(u) {
+		return text
+	}
+	if len(strings.TrimSpace(text)) == 0 {
+		text = markdownEscaper.Replace(u)
+	}
+	return "[" + text + "](" + markdownURLEscaper.Replace(u) + ")"
+}
+
+func (markdownRenderer) image(src, alt string) string {
+	if !SafeImageURL(src) {
+		return ""
+	}
+	return "![" + markdownEscaper.Replace(alt) + "](" + markdownURLEscaper.Replace(src) + ")"
+}
+
+func (markdownRenderer) table(rows [][]string) string {
+	width := 0
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	cell := strings.NewReplacer("|", `\|`, "\n", " ")
+	var out strings.Builder
+	for i, row := range rows {
+		out.WriteString("|")
+		for c := 0; c < width; c++ {
+			v := ""
+			if c < len(row) {
+				v = cell.Replace(markdownEscaper.Replace(row[c]))
+			}
+			out.WriteString(" " + v + " |")
+		}
+		out.WriteByte('\n')
+		if i == 0 {
+			out.WriteString("|" + strings.Repeat(" --- |", width) + "\n")
+		}
+	}
+	return "\n" + strings.TrimRight(out.String(), "\n") + "\n"
+}
+
+// Markdown renders the document as Markdown.
+func (d *Document) Markdown() string {
+	var (
+		out      strings.Builder
+		counters listCounters
+		prevList bool
+		inCode   bool
+	)
+	for _, b := range d.Blocks {
+		if b.Type == "code-block" {
+			if !inCode {
+				out.WriteString("\n```\n")
+				inCode = true
+			}
+			out.WriteString(b.Text + "\n")
+			continue
+		}
+		if inCode {
+			out.WriteString("```\n")
+			inCode = false
+		}
+		content := d.inline(b, markdownRenderer{})
+		ordered, isList := b.isList()
+		switch {
+		case isList:
+			if !prevList {
+				out.WriteByte('\n')
+			}
+			out.WriteString(strings.Repeat("    ", b.Depth))
+			if ordered {
+				out.WriteString(strconv.Itoa(counters.next(b.Depth)) + ". ")
+			} else {
+				out.WriteString("- ")
+			}
+			out.WriteString(content + "\n")
+		case b.headerLevel() > 0:
+			out.WriteString("\n" + strings.Repeat("#", b.headerLevel()) + " " + content + "\n")
+		case b.Type == "blockquote":
+			out.WriteString("\n> " + strings.ReplaceAll(content, "\n", "\n> ") + "\n")
+		default:
+			if len(strings.TrimSpace(content)) > 0 {
+				out.WriteString("\n" + content + "\n")
+			}
+		}
+		if !isList {
+			counters.reset()
+		}
+		prevList = isList
+	}
+	if inCode {
+		out.WriteString("```\n")
+	}
+	return strings.TrimSpace(out.String())
+}
+
+type htmlRenderer struct{}
+
+func (htmlRenderer) escape(text string) string {
+	return strings.ReplaceAll(html.EscapeString(text), "\n", "<br>")
+}
+
+var htmlStyleTags = map[string]string{
+	"BOLD":          "strong",
+	"ITALIC":        "em",
+	"UNDERLINE":     "u",
+	"STRIKETHROUGH": "s",
+	"CODE":          "code",
+	"SUPERSCRIPT":   "sup",
+	"SUBSCRIPT":     "sub",
+}
+
+func (htmlRenderer) styled(text string, styles []string) string {
+	for _, s := range styles {
+		if tag, ok := htmlStyleTags[s]; ok {
+			text = "<" + tag + ">" + text + "</" + tag + ">"
+		}
+	}
+	return text
+}
+
+func (htmlRenderer) link(text, u string) string {
+	if !SafeURL(u) {
+		return text
+	}
+	if len(strings.TrimSpace(text)) == 0 {
+		text = html.EscapeString(u)
+	}
+	return `<a href="` + html.EscapeString(u) + `" rel="nofollow noopener">` + text + `</a>`
+}
+
+func (htmlRenderer) image(src, alt string) string {
+	if !SafeImageURL(src) {
+		return ""
+	}
+	return `<img src="` + html.EscapeString(src) + `" alt="` + html.EscapeString(alt) + `">`
+}
+
+func (htmlRenderer) table(rows [][]string) string {
+	var out strings.Builder
+	out.WriteString("<table>")
+	for _, row := range rows {
+		out.WriteString("<tr>")
+		for _, c := range row {
+			out.WriteString("<td>" + html.EscapeString(c) + "</td>")
+		}
+		out.WriteString("</tr>")
+	}
+	out.WriteString("</table>")
+	return out.String()
+}
+
+// HTML renders the document as HTML. All text is escaped and the only
+// markup generated is that required to represent the document's
+// structure, styles, links, images and tables; links and images are
+// restricted to http, https, mailto and relative URLs.
+func (d *Document) HTML() string {
+	var (
+		out    strings.Builder
+		lists  []string
+		inCode bool
+	)
+	closeLists := func(depth int) {
+		for len(lists) > depth {
+			out.WriteString("</li></" + lists[len(lists)-1] + ">\n")
+			lists = lists[:len(lists)-1]
+		}
+	}
+	for _, b := range d.Blocks {
+		if b.Type == "code-block" {
+			closeLists(0)
+			if !inCode {
+				out.WriteString("<pre><code>")
+				inCode = true
+			}
+			out.WriteString(html.EscapeString(b.Text) + "\n")
+			continue
+		}
+		if inCode {
+			out.WriteString("</code></pre>\n")
+			inCode = false
+		}
+		content := d.inline(b, htmlRenderer{})
+		if ordered, ok := b.isList(); ok {
+			tag := "ul"
+			if ordered {
+				tag = "ol"
+			}
+			closeLists(b.Depth + 1)
+			if len(lists) == b.Depth+1 && lists[b.Depth] != tag {
+				closeLists(b.Depth)
+			}
+			if len(lists) == b.Depth+1 {
+				out.WriteString("</li>\n")
+			}
+			for len(lists) < b.Depth+1 {
+				out.WriteString("<" + tag + ">")
+				lists = append(lists, tag)
+				if len(lists) < b.Depth+1 {
+					out.WriteString("<li>")
+				}
+			}
+			out.WriteString("<li>" + content)
+			continue
+		}
+		closeLists(0)
+		switch {
+		case b.headerLevel() > 0:
+			h := "h" + strconv.Itoa(b.headerLevel())
+			out.WriteString("<" + h + ">" + content + "</" + h + ">\n")
+		case b.Type == "blockquote":
+			out.WriteString("<blockquote>" + content + "</blockquote>\n")
+		case b.Type == "atomic":
+			if len(strings.TrimSpace(content)) > 0 {
+				out.WriteString("<figure>" + content + "</figure>\n")
+			}
+		default:
+			if len(strings.TrimSpace(content)) > 0 {
+				out.WriteString("<p>" + content + "</p>\n")
+			}
+		}
+	}
+	closeLists(0)
+	if inCode {
+		out.WriteString("</code></pre>\n")
+	}
+	return out.String()
+}
+
+// SafeURL returns true if u is relative or uses one of the http, https
+// or mailto schemes and hence may be safely used as a link in rendered
+// markdown or HTML.
+func SafeURL(u string) bool {
+	pu, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(pu.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// SafeImageURL returns true if u is a data URI for an image or is
+// otherwise a safe URL for an image.
+func SafeImageURL(u string) bool {
+	if strings.HasPrefix(u, "data:image/") {
+		return true
+	}
+	pu, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(pu.Scheme) {
+	case "", "http", "https":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package richtext
+
+import (
+	"testing"
+)
+
+func TestSafeURL(t *testing.T) {
+	for i, tc := range []struct {
+		url       string
+		safe      bool
+		safeImage bool
+	}{
+		{"https://www.protocols.io/view/x", true, true},
+		{"http://example.com", true, true},
+		{"images/x.png", true, true},
+		{"mailto:someone@example.com", true, false},
+		{"javascript:alert(1)", false, false},
+		{" JavaScript:alert(1)", false, false},
+		{"data:text/html,<script>", false, false},
+		{"data:image/png;base64,AAAA", false, true},
+	} {
+		if got, want := SafeURL(tc.url), tc.safe; got != want {
+			t.Errorf("%v: %v: got %v, want %v", i, tc.url, got, want)
+		}
+		if got, want := SafeImageURL(tc.url), tc.safeImage; got != want {
+			t.Errorf("%v: %v: got %v, want %v", i, tc.url, got, want)
+		}
+	}
+}
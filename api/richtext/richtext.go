@@ -0,0 +1,343 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package richtext renders the Draft.js documents that protocols.io uses
+// for rich text fields, such as protocol descriptions and steps, to plain
+// text, Markdown and sanitized HTML.
+package richtext
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// Document represents a Draft.js raw content state.
+type Document struct {
+	Blocks    []Block           `json:"blocks"`
+	EntityMap map[string]Entity `json:"entityMap"`
+}
+
+// Block represents a single Draft.js content block.
+type Block struct {
+	Key               string         `json:"key"`
+	Text              string         `json:"text"`
+	Type              string         `json:"type"`
+	Depth             int            `json:"depth"`
+	InlineStyleRanges []StyleRange   `json:"inlineStyleRanges"`
+	EntityRanges      []EntityRange  `json:"entityRanges"`
+	Data              map[string]any `json:"data"`
+}
+
+// StyleRange represents an inline style applied to a range of a block's
+// text. Offsets and lengths are in UTF-16 code units as per Draft.js.
+type StyleRange struct {
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	Style  string `json:"style"`
+}
+
+// EntityRange represents an entity applied to a range of a block's text.
+type EntityRange struct {
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+	Key    int `json:"key"`
+}
+
+// Entity represents a Draft.js entity such as a link, image, table or
+// one of the protocols.io specific components such as an amount or
+// duration.
+type Entity struct {
+	Type       string         `json:"type"`
+	Mutability string         `json:"mutability"`
+	Data       map[string]any `json:"data"`
+}
+
+// Parse parses a Draft.js document. Input that is not a Draft.js
+// document is treated as plain text with each line becoming a separate
+// unstyled block.
+func Parse(s string) *Document {
+	doc := &Document{}
+	trimmed := strings.TrimSpace(s)
+	if len(trimmed) == 0 {
+		return doc
+	}
+	if strings.HasPrefix(trimmed, "{") {
+		var tmp Document
+		if err := json.Unmarshal([]byte(trimmed), &tmp); err == nil && tmp.Blocks != nil {
+			return &tmp
+		}
+	}
+	for _, line := range strings.Split(s, "\n") {
+		doc.Blocks = append(doc.Blocks, Block{Type: "unstyled", Text: line})
+	}
+	return doc
+}
+
+// Text renders s, a Draft.js document, as plain text.
+func Text(s string) string {
+	return Parse(s).Text()
+}
+
+// Markdown renders s, a Draft.js document, as Markdown.
+func Markdown(s string) string {
+	return Parse(s).Markdown()
+}
+
+// HTML renders s, a Draft.js document, as sanitized HTML.
+func HTML(s string) string {
+	return Parse(s).HTML()
+}
+
+// Entity returns the entity with the specified key.
+func (d *Document) Entity(key int) (Entity, bool) {
+	e, ok := d.EntityMap[fmt.Sprint(key)]
+	return e, ok
+}
+
+// Entities returns all entities of the specified types, in the order
+// that they appear in the document. Type comparisons are case
+// insensitive and all entities are returned if no types are specified.
+func (d *Document) Entities(types ...string) []Entity {
+	var out []Entity
+	for _, b := range d.Blocks {
+		for _, er := range b.EntityRanges {
+			e, ok := d.Entity(er.Key)
+			if !ok {
+				continue
+			}
+			if len(types) == 0 {
+				out = append(out, e)
+				continue
+			}
+			for _, t := range types {
+				if strings.EqualFold(e.Type, t) {
+					out = append(out, e)
+					break
+				}
+			}
+		}
+	}
+	return out
+}
+
+// RewriteImages replaces the source URL of every image entity with the
+// value returned by fn, for example, to refer to a local copy of the
+// image.
+func (d *Document) RewriteImages(fn func(src string) string) {
+	for _, e := range d.EntityMap {
+		if e.kind() != "image" {
+			continue
+		}
+		if src := e.ImageSource(); len(src) > 0 && e.Data != nil {
+			e.Data["src"] = fn(src)
+		}
+	}
+}
+
+// IsEmpty returns true if the document contains no text or entities.
+func (d *Document) IsEmpty() bool {
+	for _, b := range d.Blocks {
+		if len(strings.TrimSpace(b.Text)) > 0 || len(b.EntityRanges) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// run represents a contiguous range of a block's text that has the same
+// styles and entity.
+type run struct {
+	text   string
+	styles []string
+	entity int // -1 if there is no entity.
+}
+
+// span represents a sequence of runs that share the same entity.
+type span struct {
+	runs   []run
+	entity int
+}
+
+// spans splits a block's text into spans of runs with the same
+// entity, each run having the same set of styles.
+func (b Block) spans() []span {
+	units := utf16.Encode([]rune(b.Text))
+	n := len(units)
+	clamp := func(v int) int {
+		if v < 0 {
+			return 0
+		}
+		if v > n {
+			return n
+		}
+		return v
+	}
+	boundaries := map[int]bool{0: true, n: true}
+	for _, sr := range b.InlineStyleRanges {
+		boundaries[clamp(sr.Offset)] = true
+		boundaries[clamp(sr.Offset+sr.Length)] = true
+	}
+	for _, er := range b.EntityRanges {
+		boundaries[clamp(er.Offset)] = true
+		boundaries[clamp(er.Offset+er.Length)] = true
+	}
+	points := make([]int, 0, len(boundaries))
+	for p := range boundaries {
+		points = append(points, p)
+	}
+	sort.Ints(points)
+	var spans []span
+	for i := 0; i < len(points)-1; i++ {
+		start, end := points[i], points[i+1]
+		if start == end {
+			continue
+		}
+		r := run{
+			text:   string(utf16.Decode(units[start:end])),
+			entity: -1,
+		}
+		for _, sr := range b.InlineStyleRanges {
+			if sr.Offset <= start && end <= sr.Offset+sr.Length {
+				r.styles = append(r.styles, strings.ToUpper(sr.Style))
+			}
+		}
+		for _, er := range b.EntityRanges {
+			if er.Offset <= start && end <= er.Offset+er.Length {
+				r.entity = er.Key
+			}
+		}
+		if l := len(spans); l > 0 && spans[l-1].entity == r.entity && r.entity >= 0 {
+			spans[l-1].runs = append(spans[l-1].runs, r)
+			continue
+		}
+		spans = append(spans, span{runs: []run{r}, entity: r.entity})
+	}
+	return spans
+}
+
+// isList returns the type of list, ordered or unordered, if the block
+// is a list item.
+func (b Block) isList() (ordered, ok bool) {
+	switch b.Type {
+	case "unordered-list-item":
+		return false, true
+	case "ordered-list-item":
+		return true, true
+	}
+	return false, false
+}
+
+// headerLevel returns the level of a header block, or 0 if the
+// block is not a header.
+func (b Block) headerLevel() int {
+	for i, h := range []string{"header-one", "header-two", "header-three", "header-four", "header-five", "header-six"} {
+		if b.Type == h {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// stringData returns the first non-empty string value for the
+// specified keys.
+func stringData(data map[string]any, keys ...string) string {
+	for _, k := range keys {
+		if v, ok := data[k].(string); ok && len(v) > 0 {
+			return v
+		}
+	}
+	return ""
+}
+
+// LinkURL returns the URL for a link entity.
+func (e Entity) LinkURL() string {
+	return stringData(e.Data, "url", "href", "link")
+}
+
+// ImageSource returns the source URL of an image entity.
+func (e Entity) ImageSource() string {
+	if src := stringData(e.Data, "src", "source", "url", "original"); len(src) > 0 {
+		return src
+	}
+	if img, ok := e.Data["image"].(map[string]any); ok {
+		return stringData(img, "source", "src", "url")
+	}
+	return ""
+}
+
+// ImageAlt returns the alternative text for an image entity.
+func (e Entity) ImageAlt() string {
+	return stringData(e.Data, "alt", "title", "caption", "name")
+}
+
+// TableRows returns the rows of a table entity with each cell
+// converted to plain text.
+func (e Entity) TableRows() [][]string {
+	for _, k := range []string{"rows", "data", "cells", "table"} {
+		if rows, ok := e.Data[k].([]any); ok {
+			return tableRows(rows)
+		}
+	}
+	return nil
+}
+
+func tableRows(rows []any) [][]string {
+	var out [][]string
+	for _, row := range rows {
+		var cells []any
+		switch r := row.(type) {
+		case []any:
+			cells = r
+		case map[string]any:
+			if c, ok := r["cells"].([]any); ok {
+				cells = c
+			}
+		}
+		var texts []string
+		for _, c := range cells {
+			texts = append(texts, cellText(c))
+		}
+		out = append(out, texts)
+	}
+	return out
+}
+
+func cellText(c any) string {
+	switch v := c.(type) {
+	case nil:
+		return ""
+	case string:
+		// Cells may themselves contain Draft.js documents.
+		return strings.TrimSpace(Parse(v).Text())
+	case map[string]any:
+		for _, k := range []string{"value", "text", "data"} {
+			if _, ok := v[k]; ok {
+				return cellText(v[k])
+			}
+		}
+		return ""
+	}
+	return fmt.Sprint(c)
+}
+
+// kind returns the normalized type of an entity.
+func (e Entity) kind() string {
+	return strings.ToLower(e.Type)
+}
+
+// entityKind returns the entity for key and its normalized type, or
+// an empty string if there is no such entity.
+func (d *Document) entityKind(key int) (Entity, string) {
+	if key < 0 {
+		return Entity{}, ""
+	}
+	e, ok := d.Entity(key)
+	if !ok {
+		return Entity{}, ""
+	}
+	return e, e.kind()
+}
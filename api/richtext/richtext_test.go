@@ -0,0 +1,49 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package richtext
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpans(t *testing.T) {
+	for i, tc := range []struct {
+		block Block
+		spans []span
+	}{
+		{Block{Text: "plain"}, []span{
+			{runs: []run{{text: "plain", entity: -1}}, entity: -1},
+		}},
+		{Block{Text: "a bold word", InlineStyleRanges: []StyleRange{{Offset: 2, Length: 4, Style: "bold"}}}, []span{
+			{runs: []run{{text: "a ", entity: -1}}, entity: -1},
+			{runs: []run{{text: "bold", styles: []string{"BOLD"}, entity: -1}}, entity: -1},
+			{runs: []run{{text: " word", entity: -1}}, entity: -1},
+		}},
+		// Offsets are in UTF-16 code units, the emoji is a surrogate pair.
+		{Block{Text: "🧪 37°C link", InlineStyleRanges: []StyleRange{{Offset: 3, Length: 4, Style: "ITALIC"}}, EntityRanges: []EntityRange{{Offset: 8, Length: 4, Key: 0}}}, []span{
+			{runs: []run{{text: "🧪 ", entity: -1}}, entity: -1},
+			{runs: []run{{text: "37°C", styles: []string{"ITALIC"}, entity: -1}}, entity: -1},
+			{runs: []run{{text: " ", entity: -1}}, entity: -1},
+			{runs: []run{{text: "link", entity: 0}}, entity: 0},
+		}},
+		// Runs within an entity are grouped into a single span.
+		{Block{Text: "a link", InlineStyleRanges: []StyleRange{{Offset: 2, Length: 1, Style: "BOLD"}}, EntityRanges: []EntityRange{{Offset: 0, Length: 6, Key: 1}}}, []span{
+			{runs: []run{
+				{text: "a ", entity: 1},
+				{text: "l", styles: []string{"BOLD"}, entity: 1},
+				{text: "ink", entity: 1},
+			}, entity: 1},
+		}},
+		// Out of range offsets are clamped.
+		{Block{Text: "abc", InlineStyleRanges: []StyleRange{{Offset: -1, Length: 10, Style: "BOLD"}}}, []span{
+			{runs: []run{{text: "abc", styles: []string{"BOLD"}, entity: -1}}, entity: -1},
+		}},
+	} {
+		if got, want := tc.block.spans(), tc.spans; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %+v, want %+v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package diff compares two versions of a protocol, reporting changes
+// to its title and other text fields, steps and materials.
+package diff
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/api/richtext"
+)
+
+// Kinds of change.
+const (
+	Added    = "added"
+	Removed  = "removed"
+	Modified = "modified"
+)
+
+// FieldChange represents a change to a text field. Rich text fields are
+// compared as plain text.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// StepChange represents an added, removed or modified step. Steps are
+// matched by their GUID, or ID if they have no GUID.
+type StepChange struct {
+	Change    string `json:"change"`
+	GUID      string `json:"guid,omitempty"`
+	ID        int64  `json:"id,omitempty"`
+	OldNumber string `json:"old_number,omitempty"`
+	NewNumber string `json:"new_number,omitempty"`
+	// Fields lists the changes to a modified step's section, duration
+	// and text.
+	Fields []FieldChange `json:"fields,omitempty"`
+	// Text is the plain text of an added or removed step.
+	Text string `json:"text,omitempty"`
+}
+
+// Quantity represents the amount of a material.
+type Quantity struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+func (q Quantity) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%g %s", q.Value, q.Unit))
+}
+
+// MaterialChange represents an added or removed material, or a change
+// in the quantity of a material. Materials are matched by their ID, or
+// name if they have no ID.
+type MaterialChange struct {
+	Change string    `json:"change"`
+	ID     int64     `json:"id,omitempty"`
+	Name   string    `json:"name"`
+	Old    *Quantity `json:"old,omitempty"`
+	New    *Quantity `json:"new,omitempty"`
+}
+
+// Diff represents the differences between two versions of a protocol.
+type Diff struct {
+	ID         int64            `json:"id"`
+	OldVersion int              `json:"old_version"`
+	NewVersion int              `json:"new_version"`
+	Fields     []FieldChange    `json:"fields,omitempty"`
+	Steps      []StepChange     `json:"steps,omitempty"`
+	Materials  []MaterialChange `json:"materials,omitempty"`
+}
+
+// Empty returns true if there are no differences.
+func (d Diff) Empty() bool {
+	return len(d.Fields) == 0 && len(d.Steps) == 0 && len(d.Materials) == 0
+}
+
+// Protocols returns the differences between the old and new versions of
+// a protocol.
+func Protocols(old, new api.Protocol) Diff {
+	d := Diff{ID: new.ID, OldVersion: old.VersionID, NewVersion: new.VersionID}
+	d.Fields = fields([]FieldChange{
+		{"title", old.Title, new.Title},
+		{"description", richtext.Text(old.Description), richtext.Text(new.Description)},
+		{"guidelines", richtext.Text(old.Guidelines), richtext.Text(new.Guidelines)},
+		{"warning", richtext.Text(old.Warning), richtext.Text(new.Warning)},
+		{"before_start", richtext.Text(old.BeforeStart), richtext.Text(new.BeforeStart)},
+		{"materials_text", richtext.Text(old.MaterialsText), richtext.Text(new.MaterialsText)},
+	})
+	d.Steps = steps(old.Steps, new.Steps)
+	d.Materials = materials(old.Materials, new.Materials)
+	return d
+}
+
+// fields returns the candidates whose old and new values differ.
+func fields(candidates []FieldChange) []FieldChange {
+	var changes []FieldChange
+	for _, c := range candidates {
+		if c.Old != c.New {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+func stepKey(s api.Step) string {
+	if len(s.GUID) > 0 {
+		return "guid:" + s.GUID
+	}
+	if s.ID != 0 {
+		return fmt.Sprintf("id:%d", s.ID)
+	}
+	return "number:" + s.Number
+}
+
+func steps(old, new []api.Step) []StepChange {
+	oldByKey := make(map[string]api.Step, len(old))
+	for _, s := range old {
+		oldByKey[stepKey(s)] = s
+	}
+	newKeys := make(map[string]bool, len(new))
+	var changes []StepChange
+	for _, n := range new {
+		key := stepKey(n)
+		newKeys[key] = true
+		o, ok := oldByKey[key]
+		if !ok {
+			changes = append(changes, StepChange{
+				Change:    Added,
+				GUID:      n.GUID,
+				ID:        n.ID,
+				NewNumber: n.Number,
+				Text:      richtext.Text(n.Step),
+			})
+			continue
+		}
+		fc := fields([]FieldChange{
+			{"number", o.Number, n.Number},
+			{"section", o.Section, n.Section},
+			{"duration", formatDuration(o.Duration), formatDuration(n.Duration)},
+			{"text", richtext.Text(o.Step), richtext.Text(n.Step)},
+		})
+		if len(fc) > 0 {
+			changes = append(changes, StepChange{
+				Change:    Modified,
+				GUID:      n.GUID,
+				ID:        n.ID,
+				OldNumber: o.Number,
+				NewNumber: n.Number,
+				Fields:    fc,
+			})
+		}
+	}
+	for _, o := range old {
+		if !newKeys[stepKey(o)] {
+			changes = append(changes, StepChange{
+				Change:    Removed,
+				GUID:      o.GUID,
+				ID:        o.ID,
+				OldNumber: o.Number,
+				Text:      richtext.Text(o.Step),
+			})
+		}
+	}
+	return changes
+}
+
+func formatDuration(seconds int) string {
+	if seconds == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
+
+func materialKey(m api.Material) string {
+	if m.ID != 0 {
+		return fmt.Sprintf("id:%d", m.ID)
+	}
+	return "name:" + strings.ToLower(strings.TrimSpace(m.Name))
+}
+
+func materials(old, new []api.Material) []MaterialChange {
+	oldByKey := make(map[string]api.Material, len(old))
+	for _, m := range old {
+		oldByKey[materialKey(m)] = m
+	}
+	newKeys := make(map[string]bool, len(new))
+	var changes []MaterialChange
+	for _, n := range new {
+		key := materialKey(n)
+		newKeys[key] = true
+		nq := &Quantity{Value: n.Quantity, Unit: n.Unit}
+		o, ok := oldByKey[key]
+		if !ok {
+			changes = append(changes, MaterialChange{Change: Added, ID: n.ID, Name: n.Name, New: nq})
+			continue
+		}
+		if oq := (&Quantity{Value: o.Quantity, Unit: o.Unit}); *oq != *nq {
+			changes = append(changes, MaterialChange{Change: Modified, ID: n.ID, Name: n.Name, Old: oq, New: nq})
+		}
+	}
+	for _, o := range old {
+		if !newKeys[materialKey(o)] {
+			changes = append(changes, MaterialChange{
+				Change: Removed, ID: o.ID, Name: o.Name,
+				Old: &Quantity{Value: o.Quantity, Unit: o.Unit},
+			})
+		}
+	}
+	return changes
+}
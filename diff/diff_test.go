@@ -0,0 +1,104 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cosnicolaou/protocolsio/api"
+)
+
+func TestLines(t *testing.T) {
+	for i, tc := range []struct {
+		old, new string
+		lines    []string
+	}{
+		{"", "", nil},
+		{"a", "a", []string{" a"}},
+		{"", "a\nb", []string{"+a", "+b"}},
+		{"a\nb", "", []string{"-a", "-b"}},
+		{"a\nb\nc", "a\nc", []string{" a", "-b", " c"}},
+		{"a\nc", "a\nb\nc", []string{" a", "+b", " c"}},
+		{"a\nb\nc", "a\nx\nc", []string{" a", "-b", "+x", " c"}},
+		{"a\nb\nc\nd", "b\nc\nd\ne", []string{"-a", " b", " c", " d", "+e"}},
+		{"x\ny", "y\nx", []string{"-x", " y", "+x"}},
+	} {
+		if got, want := Lines(tc.old, tc.new), tc.lines; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestProtocols(t *testing.T) {
+	old := api.Protocol{
+		ID:        1,
+		VersionID: 1,
+		Title:     "Gel",
+		Steps: []api.Step{
+			{GUID: "a", Number: "1", Step: "Cast the gel."},
+			{GUID: "b", Number: "2", Step: "Load the samples."},
+			{GUID: "c", Number: "3", Step: "Run the gel."},
+		},
+		Materials: []api.Material{
+			{ID: 1, Name: "Agarose", Quantity: 1, Unit: "g"},
+			{Name: "Water", Quantity: 100, Unit: "ml"},
+		},
+	}
+	new := api.Protocol{
+		ID:        1,
+		VersionID: 2,
+		Title:     "Gel electrophoresis",
+		Steps: []api.Step{
+			{GUID: "a", Number: "1", Step: "Cast the gel."},
+			{GUID: "c", Number: "2", Step: "Run the gel.", Duration: 1800},
+			{GUID: "d", Number: "3", Step: "Image the gel."},
+		},
+		Materials: []api.Material{
+			{ID: 1, Name: "Agarose", Quantity: 1.5, Unit: "g"},
+			{Name: " water", Quantity: 100, Unit: "ml"},
+			{ID: 3, Name: "Buffer", Quantity: 1, Unit: "l"},
+		},
+	}
+	d := Protocols(old, new)
+	if got, want := d.Fields, []FieldChange{{"title", "Gel", "Gel electrophoresis"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := d.Steps, []StepChange{
+		{Change: Modified, GUID: "c", OldNumber: "3", NewNumber: "2", Fields: []FieldChange{
+			{"number", "3", "2"}, {"duration", "", "1800s"}}},
+		{Change: Added, GUID: "d", NewNumber: "3", Text: "Image the gel."},
+		{Change: Removed, GUID: "b", OldNumber: "2", Text: "Load the samples."},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got, want := d.Materials, []MaterialChange{
+		{Change: Modified, ID: 1, Name: "Agarose", Old: &Quantity{1, "g"}, New: &Quantity{1.5, "g"}},
+		{Change: Added, ID: 3, Name: "Buffer", New: &Quantity{1, "l"}},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if !Protocols(old, old).Empty() {
+		t.Errorf("expected no differences")
+	}
+	var out strings.Builder
+	if err := d.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"--- 000001 version 1",
+		"+++ 000001 version 2",
+		"@@ title @@\n-Gel\n+Gel electrophoresis\n",
+		"@@ step 2 (was 3) modified @@\n-duration: \n+duration: 1800s\n",
+		"@@ step 3 added @@\n+Image the gel.\n",
+		"@@ step 2 removed @@\n-Load the samples.\n",
+		"@@ material Agarose modified @@\n-Agarose: 1 g\n+Agarose: 1.5 g\n",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("%q is missing from:\n%v", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package diff
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Lines returns a line oriented diff of old and new with each line
+// prefixed by ' ', '-' or '+' to indicate whether it is common to both,
+// only in old or only in new.
+func Lines(old, new string) []string {
+	a, b := splitLines(old), splitLines(new)
+	// lcs[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+"+b[j])
+	}
+	return out
+}
+
+func splitLines(s string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func prefixed(prefix, text string) []string {
+	lines := splitLines(text)
+	for i, l := range lines {
+		lines[i] = prefix + l
+	}
+	return lines
+}
+
+func stepName(s StepChange) string {
+	number := s.NewNumber
+	if len(number) == 0 {
+		number = s.OldNumber
+	}
+	return fmt.Sprintf("step %v", number)
+}
+
+// WriteText writes the diff in a format similar to that of a unified
+// diff.
+func (d Diff) WriteText(w io.Writer) error {
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %06d version %v\n", d.ID, d.OldVersion)
+	fmt.Fprintf(&out, "+++ %06d version %v\n", d.ID, d.NewVersion)
+	writeLines := func(lines []string) {
+		for _, l := range lines {
+			out.WriteString(l)
+			out.WriteByte('\n')
+		}
+	}
+	for _, f := range d.Fields {
+		fmt.Fprintf(&out, "@@ %v @@\n", f.Field)
+		writeLines(Lines(f.Old, f.New))
+	}
+	for _, s := range d.Steps {
+		switch s.Change {
+		case Added:
+			fmt.Fprintf(&out, "@@ %v added @@\n", stepName(s))
+			writeLines(prefixed("+", s.Text))
+		case Removed:
+			fmt.Fprintf(&out, "@@ %v removed @@\n", stepName(s))
+			writeLines(prefixed("-", s.Text))
+		case Modified:
+			name := stepName(s)
+			if s.OldNumber != s.NewNumber {
+				name = fmt.Sprintf("step %v (was %v)", s.NewNumber, s.OldNumber)
+			}
+			fmt.Fprintf(&out, "@@ %v modified @@\n", name)
+			for _, f := range s.Fields {
+				switch f.Field {
+				case "text":
+					writeLines(Lines(f.Old, f.New))
+				case "number":
+				default:
+					fmt.Fprintf(&out, "-%v: %v\n+%v: %v\n", f.Field, f.Old, f.Field, f.New)
+				}
+			}
+		}
+	}
+	for _, m := range d.Materials {
+		fmt.Fprintf(&out, "@@ material %v %v @@\n", m.Name, m.Change)
+		if m.Old != nil {
+			fmt.Fprintf(&out, "-%v: %v\n", m.Name, m.Old)
+		}
+		if m.New != nil {
+			fmt.Fprintf(&out, "+%v: %v\n", m.Name, m.New)
+		}
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
@@ -0,0 +1,285 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package export renders protocols as standalone Markdown and HTML
+// documents suitable for printing or for browsing offline, and as
+// Bioschemas LabProtocol JSON-LD documents for use by search engines
+// and knowledge graphs.
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/api/richtext"
+)
+
+// Format represents a supported export format.
+type Format string
+
+const (
+	Markdown Format = "markdown"
+	HTML     Format = "html"
+	JSONLD   Format = "jsonld"
+)
+
+// Formats returns the supported export formats.
+func Formats() []string {
+	return []string{string(Markdown), string(HTML), string(JSONLD)}
+}
+
+// ParseFormat parses an export format.
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats() {
+		if strings.EqualFold(s, f) {
+			return Format(f), nil
+		}
+	}
+	return "", fmt.Errorf("unsupported export format: %q, must be one of: %v", s, strings.Join(Formats(), ", "))
+}
+
+// Extension returns the filename extension used for the format.
+func (f Format) Extension() string {
+	switch f {
+	case HTML:
+		return ".html"
+	case JSONLD:
+		return ".jsonld"
+	}
+	return ".md"
+}
+
+// Options control how protocols are exported.
+type Options struct {
+	// Image, if set, is called to obtain the URL to use for each image,
+	// for example, to refer to a locally cached copy.
+	Image func(src string) string
+}
+
+// Write writes p to w in the specified format.
+func Write(w io.Writer, format Format, p api.Protocol, opts Options) error {
+	switch format {
+	case HTML:
+		return writeHTML(w, p, opts)
+	case JSONLD:
+		return writeJSONLD(w, LabProtocolFor(p, opts))
+	}
+	return writeMarkdown(w, p, opts)
+}
+
+// IndexEntry represents a single exported protocol in an index.
+type IndexEntry struct {
+	Protocol api.Protocol
+	Filename string // Relative to the index.
+}
+
+// WriteIndex writes an index of the exported protocols to w in the
+// specified format. The index for JSON-LD is a single document
+// containing all of the protocols.
+func WriteIndex(w io.Writer, format Format, title string, entries []IndexEntry) error {
+	switch format {
+	case HTML:
+		return writeHTMLIndex(w, title, entries)
+	case JSONLD:
+		return writeJSONLDIndex(w, entries)
+	}
+	return writeMarkdownIndex(w, title, entries)
+}
+
+// document parses a rich text field, rewriting its images as per opts.
+func (o Options) document(s string) *richtext.Document {
+	doc := richtext.Parse(s)
+	if o.Image != nil {
+		doc.RewriteImages(o.Image)
+	}
+	return doc
+}
+
+func (o Options) image(src string) string {
+	if o.Image == nil {
+		return src
+	}
+	return o.Image(src)
+}
+
+// protocolImage returns the URL to use for the protocol's image, or an
+// empty string if it has none or its URL is not safe.
+func protocolImage(p api.Protocol, opts Options) string {
+	if p.Image == nil || len(p.Image.Source) == 0 {
+		return ""
+	}
+	if src := opts.image(p.Image.Source); richtext.SafeImageURL(src) {
+		return src
+	}
+	return ""
+}
+
+// textSection represents one of the rich text fields that precede
+// the materials and steps.
+type textSection struct {
+	title string
+	doc   *richtext.Document
+}
+
+func textSections(p api.Protocol, opts Options) []textSection {
+	var sections []textSection
+	for _, s := range []struct{ title, text string }{
+		{"Abstract", p.Description},
+		{"Guidelines", p.Guidelines},
+		{"Before start", p.BeforeStart},
+		{"Warnings", p.Warning},
+	} {
+		if doc := opts.document(s.text); !doc.IsEmpty() {
+			sections = append(sections, textSection{title: s.title, doc: doc})
+		}
+	}
+	return sections
+}
+
+// stepSection represents a consecutive sequence of steps that share the
+// same section title, which may be empty.
+type stepSection struct {
+	title string
+	steps []api.Step
+}
+
+func stepSections(steps []api.Step) []stepSection {
+	var sections []stepSection
+	for _, s := range steps {
+		if n := len(sections); n > 0 && sections[n-1].title == s.Section {
+			sections[n-1].steps = append(sections[n-1].steps, s)
+			continue
+		}
+		sections = append(sections, stepSection{title: s.Section, steps: []api.Step{s}})
+	}
+	return sections
+}
+
+// stepNumber returns the number to display for the i'th step.
+func stepNumber(s api.Step, i int) string {
+	if len(s.Number) > 0 {
+		return s.Number
+	}
+	return fmt.Sprint(i + 1)
+}
+
+// timers returns the timers for a step, either its duration or those
+// specified by the duration components within its text.
+func timers(s api.Step, doc *richtext.Document) []time.Duration {
+	if s.Duration > 0 {
+		return []time.Duration{time.Duration(s.Duration) * time.Second}
+	}
+	var durations []time.Duration
+	for _, e := range doc.Entities("duration", "timer") {
+		for _, k := range []string{"duration", "seconds", "value"} {
+			if v, ok := e.Data[k].(float64); ok && v > 0 {
+				durations = append(durations, time.Duration(v)*time.Second)
+				break
+			}
+		}
+	}
+	return durations
+}
+
+// formatDuration formats a timer as, for example, 1h 5m 30s.
+func formatDuration(d time.Duration) string {
+	var parts []string
+	for _, u := range []struct {
+		unit time.Duration
+		name string
+	}{{time.Hour, "h"}, {time.Minute, "m"}, {time.Second, "s"}} {
+		if n := d / u.unit; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d%s", n, u.name))
+			d -= n * u.unit
+		}
+	}
+	if len(parts) == 0 {
+		return "0s"
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatTimers(durations []time.Duration) string {
+	s := make([]string, len(durations))
+	for i, d := range durations {
+		s[i] = formatDuration(d)
+	}
+	return strings.Join(s, ", ")
+}
+
+// amount returns the quantity and unit of a material.
+func amount(m api.Material) string {
+	if m.Quantity == 0 {
+		return m.Unit
+	}
+	return strings.TrimSpace(fmt.Sprintf("%g %s", m.Quantity, m.Unit))
+}
+
+// identifiers returns the catalog number, RRID and CAS number of a
+// material.
+func identifiers(m api.Material) string {
+	var ids []string
+	for _, id := range []struct{ label, value string }{
+		{"Cat #", m.SKU},
+		{"RRID", m.RRID},
+		{"CAS", m.CASNumber},
+	} {
+		if len(id.value) > 0 {
+			ids = append(ids, id.label+" "+id.value)
+		}
+	}
+	return strings.Join(ids, ", ")
+}
+
+func vendor(m api.Material) string {
+	if m.Vendor == nil {
+		return ""
+	}
+	return m.Vendor.Name
+}
+
+// doiURL returns the DOI as a URL.
+func doiURL(doi string) string {
+	doi = strings.TrimPrefix(strings.TrimPrefix(doi, "https://"), "http://")
+	doi = strings.TrimPrefix(strings.TrimPrefix(doi, "dx.doi.org/"), "doi.org/")
+	if len(doi) == 0 {
+		return ""
+	}
+	return "https://doi.org/" + doi
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// authors returns the protocol's authors, or its creator if it has no
+// authors.
+func authors(p api.Protocol) []api.Author {
+	if len(p.Authors) > 0 {
+		return p.Authors
+	}
+	if len(p.Creator.Name) > 0 {
+		return []api.Author{p.Creator}
+	}
+	return nil
+}
+
+func affiliation(a api.Author) string {
+	if len(a.Affiliation) > 0 {
+		return a.Affiliation
+	}
+	var affs []string
+	for _, af := range a.Affiliations {
+		if len(af.Affiliation) > 0 {
+			affs = append(affs, af.Affiliation)
+		}
+	}
+	return strings.Join(affs, "; ")
+}
@@ -0,0 +1,171 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/api/richtext"
+)
+
+// stylesheet is included in every HTML document so that they are
+// readable, and printable, without any external resources.
+const stylesheet = `body { font-family: sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; line-height: 1.5; }
+img { max-width: 100%; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.5em; text-align: left; vertical-align: top; }
+.metadata { color: #444; }
+.step { margin-bottom: 1.5em; page-break-inside: avoid; }
+.timer { border-left: 4px solid #4a90d9; padding-left: 0.5em; }
+.warnings { border-left: 4px solid #d9534f; padding-left: 0.5em; }
+@media print { a { color: inherit; text-decoration: none; } }
+`
+
+var escape = html.EscapeString
+
+// htmlLink returns a link to u, or just text if u is empty or is not
+// a safe URL.
+func htmlLink(text, u string) string {
+	if len(u) == 0 || !richtext.SafeURL(u) {
+		return text
+	}
+	return `<a href="` + escape(u) + `">` + text + `</a>`
+}
+
+func writeHTMLHeader(out io.Writer, title string) {
+	fmt.Fprintf(out, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n<title>%s</title>\n<style>\n%s</style>\n</head>\n<body>\n", escape(title), stylesheet)
+}
+
+func writeHTMLFooter(out io.Writer) {
+	fmt.Fprintf(out, "</body>\n</html>\n")
+}
+
+func writeHTML(w io.Writer, p api.Protocol, opts Options) error {
+	out := bufio.NewWriter(w)
+	writeHTMLHeader(out, p.Title)
+	fmt.Fprintf(out, "<h1>%s</h1>\n", escape(p.Title))
+	writeHTMLMetadata(out, p)
+	if src := protocolImage(p, opts); len(src) > 0 {
+		fmt.Fprintf(out, "<figure><img src=\"%s\" alt=\"%s\"></figure>\n", escape(src), escape(p.Title))
+	}
+	for _, s := range textSections(p, opts) {
+		class := ""
+		if s.title == "Warnings" {
+			class = ` class="warnings"`
+		}
+		fmt.Fprintf(out, "<section%s>\n<h2>%s</h2>\n%s</section>\n", class, escape(s.title), s.doc.HTML())
+	}
+	writeHTMLMaterials(out, p, opts)
+	writeHTMLSteps(out, p, opts)
+	writeHTMLFooter(out)
+	return out.Flush()
+}
+
+func writeHTMLMetadata(out io.Writer, p api.Protocol) {
+	var names []string
+	for _, a := range authors(p) {
+		name := escape(a.Name)
+		if u := a.ORCIDURL(); len(u) > 0 {
+			name = htmlLink(name, u)
+		}
+		if aff := affiliation(a); len(aff) > 0 {
+			name += " (" + escape(aff) + ")"
+		}
+		names = append(names, name)
+	}
+	fmt.Fprintf(out, "<div class=\"metadata\">\n")
+	if len(names) > 0 {
+		fmt.Fprintf(out, "<p><strong>Authors:</strong> %s</p>\n", strings.Join(names, ", "))
+	}
+	if u := doiURL(p.DOI); len(u) > 0 {
+		fmt.Fprintf(out, "<p><strong>DOI:</strong> %s</p>\n", htmlLink(escape(u), u))
+	}
+	fmt.Fprintf(out, "<p><strong>Version:</strong> %v", p.VersionID)
+	if d := formatDate(p.PublishedOn); len(d) > 0 {
+		fmt.Fprintf(out, ", <strong>Published:</strong> %s", d)
+	}
+	if d := formatDate(p.ChangedOn); len(d) > 0 {
+		fmt.Fprintf(out, ", <strong>Last modified:</strong> %s", d)
+	}
+	fmt.Fprintf(out, "</p>\n")
+	if p.License != nil && len(p.License.Title) > 0 {
+		fmt.Fprintf(out, "<p><strong>License:</strong> %s</p>\n", htmlLink(escape(p.License.Title), p.License.Link))
+	}
+	if len(p.URL) > 0 {
+		fmt.Fprintf(out, "<p><strong>Source:</strong> %s</p>\n", htmlLink(escape(p.URL), p.URL))
+	}
+	fmt.Fprintf(out, "</div>\n")
+}
+
+func writeHTMLMaterials(out io.Writer, p api.Protocol, opts Options) {
+	doc := opts.document(p.MaterialsText)
+	if len(p.Materials) == 0 && doc.IsEmpty() {
+		return
+	}
+	fmt.Fprintf(out, "<section>\n<h2>Materials</h2>\n")
+	if !doc.IsEmpty() {
+		fmt.Fprint(out, doc.HTML())
+	}
+	if len(p.Materials) > 0 {
+		fmt.Fprintf(out, "<table>\n<tr><th>Name</th><th>Amount</th><th>Vendor</th><th>Identifiers</th></tr>\n")
+		for _, m := range p.Materials {
+			fmt.Fprintf(out, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+				htmlLink(escape(m.Name), m.URL), escape(amount(m)), escape(vendor(m)), escape(identifiers(m)))
+		}
+		fmt.Fprintf(out, "</table>\n")
+	}
+	fmt.Fprintf(out, "</section>\n")
+}
+
+func writeHTMLSteps(out io.Writer, p api.Protocol, opts Options) {
+	if len(p.Steps) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "<section>\n<h2>Steps</h2>\n")
+	n := 0
+	for _, section := range stepSections(p.Steps) {
+		if len(section.title) > 0 {
+			fmt.Fprintf(out, "<h3>%s</h3>\n", escape(section.title))
+		}
+		for _, s := range section.steps {
+			doc := opts.document(s.Step)
+			fmt.Fprintf(out, "<div class=\"step\">\n<h4>Step %s</h4>\n%s", escape(stepNumber(s, n)), doc.HTML())
+			if t := timers(s, doc); len(t) > 0 {
+				fmt.Fprintf(out, "<p class=\"timer\"><strong>Timer:</strong> %s</p>\n", escape(formatTimers(t)))
+			}
+			fmt.Fprintf(out, "</div>\n")
+			n++
+		}
+	}
+	fmt.Fprintf(out, "</section>\n")
+}
+
+func writeHTMLIndex(w io.Writer, title string, entries []IndexEntry) error {
+	out := bufio.NewWriter(w)
+	writeHTMLHeader(out, title)
+	fmt.Fprintf(out, "<h1>%s</h1>\n<ul>\n", escape(title))
+	for _, e := range entries {
+		line := htmlLink(escape(e.Protocol.Title), e.Filename)
+		var names []string
+		for _, a := range authors(e.Protocol) {
+			names = append(names, escape(a.Name))
+		}
+		if len(names) > 0 {
+			line += ", " + strings.Join(names, ", ")
+		}
+		if d := formatDate(e.Protocol.PublishedOn); len(d) > 0 {
+			line += ", " + d
+		}
+		fmt.Fprintf(out, "<li>%s</li>\n", line)
+	}
+	fmt.Fprintf(out, "</ul>\n")
+	writeHTMLFooter(out)
+	return out.Flush()
+}
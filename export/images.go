@@ -0,0 +1,149 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package export
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/api/richtext"
+	"github.com/cosnicolaou/protocolsio/store"
+)
+
+// maxImageSize is the largest image that will be downloaded.
+const maxImageSize = 32 << 20
+
+// ImageCache stores local copies of the images referred to by protocols
+// so that exported protocols can be viewed without access to
+// protocols.io. Each image is stored in a file whose name is derived
+// from its URL.
+type ImageCache struct {
+	dir string
+}
+
+// NewImageCache returns an ImageCache that stores images in dir.
+func NewImageCache(dir string) *ImageCache {
+	return &ImageCache{dir: dir}
+}
+
+// Filename returns the name of the file used to store the image with
+// the specified URL.
+func (ic *ImageCache) Filename(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	name := hex.EncodeToString(sum[:16])
+	if u, err := url.Parse(src); err == nil {
+		if ext := strings.ToLower(path.Ext(u.Path)); len(ext) > 1 && len(ext) <= 5 {
+			name += ext
+		}
+	}
+	return filepath.Join(ic.dir, name)
+}
+
+// Cached returns the name of the file containing the image with the
+// specified URL and true if it is cached.
+func (ic *ImageCache) Cached(src string) (string, bool) {
+	filename := ic.Filename(src)
+	fi, err := os.Stat(filename)
+	return filename, err == nil && fi.Mode().IsRegular()
+}
+
+// Fetch downloads the image with the specified URL, unless it is already
+// cached. Only http and https URLs are downloaded.
+func (ic *ImageCache) Fetch(ctx context.Context, client *http.Client, src string) error {
+	if !remote(src) {
+		return nil
+	}
+	filename, ok := ic.Cached(src)
+	if ok {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: %v", src, resp.Status)
+	}
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return fmt.Errorf("%v: %v", src, err)
+	}
+	if len(buf) > maxImageSize {
+		return fmt.Errorf("%v: image is larger than %v bytes", src, maxImageSize)
+	}
+	if err := os.MkdirAll(ic.dir, 0700); err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(filename, buf, 0600)
+}
+
+// DataURI returns the cached image with the specified URL as a data URI
+// and true if it is cached.
+func (ic *ImageCache) DataURI(src string) (string, bool) {
+	filename, ok := ic.Cached(src)
+	if !ok {
+		return "", false
+	}
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return "", false
+	}
+	mimeType := http.DetectContentType(buf)
+	if !strings.HasPrefix(mimeType, "image/") {
+		// Sniffing does not recognise all image formats, eg. svg.
+		if strings.HasSuffix(filename, ".svg") {
+			mimeType = "image/svg+xml"
+		} else {
+			return "", false
+		}
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(buf), true
+}
+
+func remote(src string) bool {
+	u, err := url.Parse(src)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// ImageSources returns the URLs of all of the images referred to by p.
+func ImageSources(p api.Protocol) []string {
+	var srcs []string
+	seen := map[string]bool{}
+	add := func(src string) {
+		if len(src) > 0 && !seen[src] {
+			seen[src] = true
+			srcs = append(srcs, src)
+		}
+	}
+	if p.Image != nil {
+		add(p.Image.Source)
+	}
+	texts := []string{p.Description, p.Guidelines, p.BeforeStart, p.Warning, p.MaterialsText}
+	for _, s := range p.Steps {
+		texts = append(texts, s.Step)
+	}
+	for _, text := range texts {
+		for _, e := range richtext.Parse(text).Entities("image") {
+			add(e.ImageSource())
+		}
+	}
+	return srcs
+}
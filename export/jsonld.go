@@ -0,0 +1,276 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/api/richtext"
+)
+
+// LabProtocolProfile is the Bioschemas profile that exported JSON-LD
+// documents conform to.
+const LabProtocolProfile = "https://bioschemas.org/profiles/LabProtocol/0.5-DRAFT"
+
+// jsonldContext maps the terms used in exported documents to schema.org,
+// Bioschemas and Dublin Core.
+var jsonldContext = map[string]string{
+	"@vocab":     "https://schema.org/",
+	"bioschemas": "https://bioschemas.org/",
+	"dct":        "http://purl.org/dc/terms/",
+}
+
+// LabProtocol represents a protocol as a Bioschemas LabProtocol, which
+// is also a schema.org HowTo, for encoding as JSON-LD.
+type LabProtocol struct {
+	Context       any           `json:"@context,omitempty"`
+	Type          []string      `json:"@type"`
+	ID            string        `json:"@id,omitempty"`
+	ConformsTo    *Ref          `json:"dct:conformsTo,omitempty"`
+	Identifier    string        `json:"identifier,omitempty"`
+	Name          string        `json:"name,omitempty"`
+	Description   string        `json:"description,omitempty"`
+	URL           string        `json:"url,omitempty"`
+	Version       int           `json:"version,omitempty"`
+	DateCreated   string        `json:"dateCreated,omitempty"`
+	DatePublished string        `json:"datePublished,omitempty"`
+	DateModified  string        `json:"dateModified,omitempty"`
+	License       string        `json:"license,omitempty"`
+	Keywords      []string      `json:"keywords,omitempty"`
+	Image         string        `json:"image,omitempty"`
+	Author        []Person      `json:"author,omitempty"`
+	Supply        []Supply      `json:"supply,omitempty"`
+	ReagentUsed   []Ref         `json:"bioschemas:reagentUsed,omitempty"`
+	TotalTime     string        `json:"totalTime,omitempty"`
+	Step          []StepElement `json:"step,omitempty"`
+}
+
+// Ref is a JSON-LD reference to another node.
+type Ref struct {
+	ID string `json:"@id"`
+}
+
+// Person represents an author.
+type Person struct {
+	Type        string        `json:"@type"`
+	ID          string        `json:"@id,omitempty"`
+	Name        string        `json:"name"`
+	Identifier  string        `json:"identifier,omitempty"`
+	Affiliation *Organization `json:"affiliation,omitempty"`
+}
+
+// Organization represents an author's affiliation.
+type Organization struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+// Supply represents a material as a HowToSupply. Its @id is used to
+// refer to it from the protocol's bioschemas:reagentUsed property.
+type Supply struct {
+	Type             string             `json:"@type"`
+	ID               string             `json:"@id,omitempty"`
+	Name             string             `json:"name"`
+	URL              string             `json:"url,omitempty"`
+	Identifier       []string           `json:"identifier,omitempty"`
+	RequiredQuantity *QuantitativeValue `json:"requiredQuantity,omitempty"`
+	Vendor           string             `json:"brand,omitempty"`
+}
+
+// QuantitativeValue represents the amount of a material.
+type QuantitativeValue struct {
+	Type     string  `json:"@type"`
+	Value    float64 `json:"value"`
+	UnitText string  `json:"unitText,omitempty"`
+}
+
+// StepElement is either a HowToStep or a HowToSection containing
+// HowToSteps.
+type StepElement struct {
+	Type            string        `json:"@type"`
+	Position        int           `json:"position"`
+	Name            string        `json:"name,omitempty"`
+	Text            string        `json:"text,omitempty"`
+	Image           []string      `json:"image,omitempty"`
+	TimeRequired    string        `json:"timeRequired,omitempty"`
+	ItemListElement []StepElement `json:"itemListElement,omitempty"`
+}
+
+// LabProtocolFor returns the LabProtocol representation of p. Image URLs
+// are mapped as per opts.
+func LabProtocolFor(p api.Protocol, opts Options) LabProtocol {
+	lp := LabProtocol{
+		Context:       jsonldContext,
+		Type:          []string{"bioschemas:LabProtocol", "HowTo"},
+		ConformsTo:    &Ref{ID: LabProtocolProfile},
+		Identifier:    doiURL(p.DOI),
+		Name:          p.Title,
+		Description:   strings.TrimSpace(richtext.Text(p.Description)),
+		URL:           p.URL,
+		Version:       p.VersionID,
+		DateCreated:   formatDateTime(p.CreatedOn),
+		DatePublished: formatDateTime(p.PublishedOn),
+		DateModified:  formatDateTime(p.ChangedOn),
+		Keywords:      p.KeywordList(),
+	}
+	lp.ID = lp.Identifier
+	if len(lp.ID) == 0 {
+		lp.ID = p.URL
+	}
+	if p.License != nil {
+		lp.License = p.License.Link
+		if len(lp.License) == 0 {
+			lp.License = p.License.Title
+		}
+	}
+	if p.Image != nil && len(p.Image.Source) > 0 {
+		lp.Image = opts.image(p.Image.Source)
+	}
+	for _, a := range authors(p) {
+		person := Person{Type: "Person", Name: a.Name}
+		if u := a.ORCIDURL(); len(u) > 0 {
+			person.ID, person.Identifier = u, u
+		}
+		if aff := affiliation(a); len(aff) > 0 {
+			person.Affiliation = &Organization{Type: "Organization", Name: aff}
+		}
+		lp.Author = append(lp.Author, person)
+	}
+	for _, m := range p.Materials {
+		s := Supply{Type: "HowToSupply", Name: m.Name, URL: m.URL, Vendor: vendor(m)}
+		if m.ID != 0 {
+			s.ID = materialID(lp.ID, m.ID)
+		}
+		for _, id := range []struct{ prefix, value string }{
+			{"", m.SKU},
+			{"RRID:", strings.TrimPrefix(m.RRID, "RRID:")},
+			{"CAS:", m.CASNumber},
+		} {
+			if len(id.value) > 0 {
+				s.Identifier = append(s.Identifier, id.prefix+id.value)
+			}
+		}
+		if m.Quantity != 0 {
+			s.RequiredQuantity = &QuantitativeValue{Type: "QuantitativeValue", Value: m.Quantity, UnitText: m.Unit}
+		}
+		lp.Supply = append(lp.Supply, s)
+		if len(s.ID) > 0 {
+			lp.ReagentUsed = append(lp.ReagentUsed, Ref{ID: s.ID})
+		}
+	}
+	var total time.Duration
+	n := 0
+	for _, section := range stepSections(p.Steps) {
+		steps := make([]StepElement, 0, len(section.steps))
+		for i, s := range section.steps {
+			doc := opts.document(s.Step)
+			step := StepElement{
+				Type:     "HowToStep",
+				Position: i + 1,
+				Name:     "Step " + stepNumber(s, n),
+				Text:     strings.TrimSpace(doc.Text()),
+			}
+			for _, e := range doc.Entities("image") {
+				if src := e.ImageSource(); len(src) > 0 {
+					step.Image = append(step.Image, src)
+				}
+			}
+			var required time.Duration
+			for _, t := range timers(s, doc) {
+				required += t
+			}
+			step.TimeRequired = isoDuration(required)
+			total += required
+			steps = append(steps, step)
+			n++
+		}
+		if len(section.title) == 0 {
+			for _, s := range steps {
+				s.Position = len(lp.Step) + 1
+				lp.Step = append(lp.Step, s)
+			}
+			continue
+		}
+		lp.Step = append(lp.Step, StepElement{
+			Type:            "HowToSection",
+			Position:        len(lp.Step) + 1,
+			Name:            section.title,
+			ItemListElement: steps,
+		})
+	}
+	lp.TotalTime = isoDuration(total)
+	return lp
+}
+
+// materialID returns the @id for the material with the specified id. It
+// is a fragment of the protocol's @id so that it is unique when multiple
+// protocols are written to a single document.
+func materialID(protocolID string, id int64) string {
+	return fmt.Sprintf("%v#material-%v", protocolID, id)
+}
+
+// formatDateTime formats t as an ISO 8601 date and time.
+func formatDateTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// isoDuration formats d as an ISO 8601 duration, eg. PT1H5M30S.
+func isoDuration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	var out strings.Builder
+	out.WriteString("PT")
+	for _, u := range []struct {
+		unit time.Duration
+		name string
+	}{{time.Hour, "H"}, {time.Minute, "M"}, {time.Second, "S"}} {
+		if n := d / u.unit; n > 0 {
+			fmt.Fprintf(&out, "%d%s", n, u.name)
+			d -= n * u.unit
+		}
+	}
+	return out.String()
+}
+
+func writeJSONLD(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// graph represents multiple protocols in a single JSON-LD document.
+type graph struct {
+	Context any           `json:"@context"`
+	Graph   []LabProtocol `json:"@graph"`
+}
+
+// WriteGraph writes the protocols to w as a single JSON-LD document.
+func WriteGraph(w io.Writer, protocols []api.Protocol, opts Options) error {
+	g := graph{Context: jsonldContext, Graph: make([]LabProtocol, len(protocols))}
+	for i, p := range protocols {
+		lp := LabProtocolFor(p, opts)
+		lp.Context = nil
+		g.Graph[i] = lp
+	}
+	return writeJSONLD(w, g)
+}
+
+func writeJSONLDIndex(w io.Writer, entries []IndexEntry) error {
+	protocols := make([]api.Protocol, len(entries))
+	for i, e := range entries {
+		protocols[i] = e.Protocol
+	}
+	return WriteGraph(w, protocols, Options{})
+}
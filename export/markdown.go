@@ -0,0 +1,153 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/api/richtext"
+)
+
+var (
+	mdEscape      = richtext.EscapeMarkdown
+	mdURLEscaper  = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29")
+	mdCellEscaper = strings.NewReplacer("|", `\|`, "\n", " ")
+)
+
+// mdLink returns a link to u, or just text if u is empty or is not
+// a safe URL.
+func mdLink(text, u string) string {
+	if len(u) == 0 || !richtext.SafeURL(u) {
+		return text
+	}
+	return "[" + text + "](" + mdURLEscaper.Replace(u) + ")"
+}
+
+func mdCell(text string) string {
+	return mdCellEscaper.Replace(mdEscape(text))
+}
+
+func writeMarkdown(w io.Writer, p api.Protocol, opts Options) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "# %s\n\n", mdEscape(p.Title))
+	writeMarkdownMetadata(out, p)
+	if src := protocolImage(p, opts); len(src) > 0 {
+		fmt.Fprintf(out, "![%s](%s)\n\n", mdEscape(p.Title), mdURLEscaper.Replace(src))
+	}
+	for _, s := range textSections(p, opts) {
+		fmt.Fprintf(out, "## %s\n\n%s\n\n", s.title, s.doc.Markdown())
+	}
+	writeMarkdownMaterials(out, p, opts)
+	writeMarkdownSteps(out, p, opts)
+	return out.Flush()
+}
+
+func writeMarkdownMetadata(out io.Writer, p api.Protocol) {
+	var names []string
+	for _, a := range authors(p) {
+		name := mdEscape(a.Name)
+		if u := a.ORCIDURL(); len(u) > 0 {
+			name = mdLink(name, u)
+		}
+		if aff := affiliation(a); len(aff) > 0 {
+			name += " (" + mdEscape(aff) + ")"
+		}
+		names = append(names, name)
+	}
+	var lines []string
+	if len(names) > 0 {
+		lines = append(lines, "**Authors:** "+strings.Join(names, ", "))
+	}
+	if u := doiURL(p.DOI); len(u) > 0 {
+		lines = append(lines, "**DOI:** "+mdLink(mdEscape(u), u))
+	}
+	version := fmt.Sprintf("**Version:** %v", p.VersionID)
+	if d := formatDate(p.PublishedOn); len(d) > 0 {
+		version += ", **Published:** " + d
+	}
+	if d := formatDate(p.ChangedOn); len(d) > 0 {
+		version += ", **Last modified:** " + d
+	}
+	lines = append(lines, version)
+	if p.License != nil && len(p.License.Title) > 0 {
+		lines = append(lines, "**License:** "+mdLink(mdEscape(p.License.Title), p.License.Link))
+	}
+	if len(p.URL) > 0 {
+		lines = append(lines, "**Source:** "+mdLink(mdEscape(p.URL), p.URL))
+	}
+	// Trailing double spaces are Markdown line breaks.
+	fmt.Fprintf(out, "%s\n\n", strings.Join(lines, "  \n"))
+}
+
+func writeMarkdownMaterials(out io.Writer, p api.Protocol, opts Options) {
+	doc := opts.document(p.MaterialsText)
+	if len(p.Materials) == 0 && doc.IsEmpty() {
+		return
+	}
+	fmt.Fprintf(out, "## Materials\n\n")
+	if !doc.IsEmpty() {
+		fmt.Fprintf(out, "%s\n\n", doc.Markdown())
+	}
+	if len(p.Materials) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "| Name | Amount | Vendor | Identifiers |\n| --- | --- | --- | --- |\n")
+	for _, m := range p.Materials {
+		name := mdCell(m.Name)
+		if len(m.URL) > 0 {
+			name = mdLink(name, m.URL)
+		}
+		fmt.Fprintf(out, "| %s | %s | %s | %s |\n", name, mdCell(amount(m)), mdCell(vendor(m)), mdCell(identifiers(m)))
+	}
+	fmt.Fprintln(out)
+}
+
+func writeMarkdownSteps(out io.Writer, p api.Protocol, opts Options) {
+	if len(p.Steps) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "## Steps\n\n")
+	n := 0
+	for _, section := range stepSections(p.Steps) {
+		if len(section.title) > 0 {
+			fmt.Fprintf(out, "### %s\n\n", mdEscape(section.title))
+		}
+		for _, s := range section.steps {
+			doc := opts.document(s.Step)
+			fmt.Fprintf(out, "#### Step %s\n\n", mdEscape(stepNumber(s, n)))
+			if text := doc.Markdown(); len(text) > 0 {
+				fmt.Fprintf(out, "%s\n\n", text)
+			}
+			if t := timers(s, doc); len(t) > 0 {
+				fmt.Fprintf(out, "> **Timer:** %s\n\n", formatTimers(t))
+			}
+			n++
+		}
+	}
+}
+
+func writeMarkdownIndex(w io.Writer, title string, entries []IndexEntry) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "# %s\n\n", mdEscape(title))
+	for _, e := range entries {
+		line := "- " + mdLink(mdEscape(e.Protocol.Title), e.Filename)
+		var names []string
+		for _, a := range authors(e.Protocol) {
+			names = append(names, mdEscape(a.Name))
+		}
+		if len(names) > 0 {
+			line += ", " + strings.Join(names, ", ")
+		}
+		if d := formatDate(e.Protocol.PublishedOn); len(d) > 0 {
+			line += ", " + d
+		}
+		fmt.Fprintln(out, line)
+	}
+	return out.Flush()
+}
@@ -0,0 +1,178 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// labProtocolProperties lists the properties that the Bioschemas
+// LabProtocol profile requires (its minimum properties) and those that
+// it recommends.
+var labProtocolProperties = struct {
+	required, recommended []string
+}{
+	required:    []string{"dct:conformsTo", "description", "name", "url"},
+	recommended: []string{"author", "datePublished", "identifier", "keywords", "license", "step", "version"},
+}
+
+// Issue represents a problem found when validating a JSON-LD document
+// against the Bioschemas LabProtocol profile. Issues that are not
+// Required correspond to recommended properties or to properties that
+// are present but have unexpected values.
+type Issue struct {
+	Property string
+	Required bool
+	Message  string
+}
+
+func (i Issue) String() string {
+	level := "warning"
+	if i.Required {
+		level = "error"
+	}
+	return fmt.Sprintf("%v: %v: %v", level, i.Property, i.Message)
+}
+
+// HasErrors returns true if any of the issues are for required
+// properties.
+func HasErrors(issues []Issue) bool {
+	for _, i := range issues {
+		if i.Required {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateLabProtocol validates a JSON-LD document, as written by Write
+// for JSONLD, against the Bioschemas LabProtocol profile: the required
+// properties must be present and non-empty, the recommended ones should
+// be, and the steps, supplies and authors must be well formed.
+func ValidateLabProtocol(data []byte) ([]Issue, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON-LD document: %v", err)
+	}
+	var issues []Issue
+	report := func(property string, required bool, format string, args ...any) {
+		issues = append(issues, Issue{Property: property, Required: required, Message: fmt.Sprintf(format, args...)})
+	}
+	if !hasType(doc, "LabProtocol") {
+		report("@type", true, "is not a LabProtocol: %v", doc["@type"])
+	}
+	for _, p := range labProtocolProperties.required {
+		if empty(doc[p]) {
+			report(p, true, "required property is missing")
+		}
+	}
+	for _, p := range labProtocolProperties.recommended {
+		if empty(doc[p]) {
+			report(p, false, "recommended property is missing")
+		}
+	}
+	if ref, ok := doc["dct:conformsTo"].(map[string]any); ok {
+		if id, _ := ref["@id"].(string); !strings.HasPrefix(id, "https://bioschemas.org/profiles/LabProtocol/") {
+			report("dct:conformsTo", true, "does not refer to the LabProtocol profile: %q", id)
+		}
+	}
+	for i, a := range list(doc["author"]) {
+		prop := fmt.Sprintf("author[%v]", i)
+		author, _ := a.(map[string]any)
+		if empty(author["name"]) {
+			report(prop, true, "author has no name")
+		}
+		if id, ok := author["identifier"].(string); ok && !strings.HasPrefix(id, "https://orcid.org/") {
+			report(prop, false, "identifier is not an ORCID URL: %q", id)
+		}
+	}
+	supplies := map[string]bool{}
+	for i, s := range list(doc["supply"]) {
+		supply, _ := s.(map[string]any)
+		if !hasType(supply, "HowToSupply") || empty(supply["name"]) {
+			report(fmt.Sprintf("supply[%v]", i), true, "supply must be a HowToSupply with a name")
+		}
+		if id, ok := supply["@id"].(string); ok {
+			supplies[id] = true
+		}
+	}
+	for i, r := range list(doc["bioschemas:reagentUsed"]) {
+		ref, _ := r.(map[string]any)
+		if id, _ := ref["@id"].(string); !supplies[id] {
+			report(fmt.Sprintf("bioschemas:reagentUsed[%v]", i), false, "does not refer to a supply: %q", id)
+		}
+	}
+	for i, s := range list(doc["step"]) {
+		validateStep(fmt.Sprintf("step[%v]", i), s, report)
+	}
+	return issues, nil
+}
+
+func validateStep(prop string, s any, report func(property string, required bool, format string, args ...any)) {
+	step, _ := s.(map[string]any)
+	switch {
+	case hasType(step, "HowToStep"):
+		if empty(step["text"]) && empty(step["itemListElement"]) {
+			report(prop, false, "step has no text")
+		}
+	case hasType(step, "HowToSection"):
+		if empty(step["name"]) {
+			report(prop, false, "section has no name")
+		}
+		elements := list(step["itemListElement"])
+		if len(elements) == 0 {
+			report(prop, true, "section contains no steps")
+		}
+		for i, e := range elements {
+			validateStep(fmt.Sprintf("%v.itemListElement[%v]", prop, i), e, report)
+		}
+	default:
+		report(prop, true, "step must be a HowToStep or HowToSection")
+	}
+}
+
+// hasType returns true if the node's @type is, or includes, the
+// specified type, with or without a prefix or namespace URL.
+func hasType(node map[string]any, typ string) bool {
+	for _, t := range list(node["@type"]) {
+		if s, ok := t.(string); ok {
+			if i := strings.LastIndexAny(s, ":/#"); i >= 0 {
+				s = s[i+1:]
+			}
+			if s == typ {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// list returns v as a list, JSON-LD allows a single value to be used
+// in place of a list of one value.
+func list(v any) []any {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []any:
+		return v
+	}
+	return []any{v}
+}
+
+func empty(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return len(strings.TrimSpace(v)) == 0
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		return len(v) == 0
+	}
+	return false
+}
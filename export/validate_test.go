@@ -0,0 +1,167 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cosnicolaou/protocolsio/api"
+)
+
+const testProtocol = `{
+	"id": 1,
+	"title": "Gel electrophoresis",
+	"url": "https://www.protocols.io/view/gel-electrophoresis-abc1",
+	"doi": "dx.doi.org/10.17504/protocols.io.abc1",
+	"version_id": 2,
+	"description": "Separate DNA fragments.",
+	"keywords": "gel, dna",
+	"published_on": 1654084800,
+	"authors": [{"name": "A. Author", "orcid": "0000-0001-2345-6789"}],
+	"materials": [{"id": 7, "name": "Agarose", "quantity": 1.5, "unit": "g"}],
+	"steps": [
+		{"id": 10, "number": "1", "step": "Cast the gel.", "duration": 1800},
+		{"id": 11, "number": "2", "step": "Load the samples."}
+	]
+}`
+
+func TestLabProtocol(t *testing.T) {
+	var p api.Protocol
+	if err := json.Unmarshal([]byte(testProtocol), &p); err != nil {
+		t.Fatal(err)
+	}
+	lp := LabProtocolFor(p, Options{})
+	if got, want := lp.ID, "https://doi.org/10.17504/protocols.io.abc1"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(lp.Supply), 1; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got, want := lp.Supply[0].ID, lp.ID+"#material-7"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := lp.ReagentUsed, []Ref{{ID: lp.Supply[0].ID}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := lp.TotalTime, "PT30M"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, JSONLD, p, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	issues, err := ValidateLabProtocol(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, issue := range issues {
+		if issue.Property != "license" {
+			t.Errorf("unexpected issue: %v", issue)
+		}
+	}
+}
+
+func TestValidateLabProtocol(t *testing.T) {
+	valid := map[string]any{
+		"@type":          []any{"bioschemas:LabProtocol", "HowTo"},
+		"dct:conformsTo": map[string]any{"@id": LabProtocolProfile},
+		"name":           "name",
+		"description":    "description",
+		"url":            "https://www.protocols.io/view/x",
+		"author":         []any{map[string]any{"@type": "Person", "name": "A. Author"}},
+		"datePublished":  "2022-06-01T12:00:00Z",
+		"identifier":     "https://doi.org/10.17504/protocols.io.x",
+		"keywords":       []any{"gel"},
+		"license":        "https://creativecommons.org/licenses/by/4.0/",
+		"version":        1,
+		"step":           []any{map[string]any{"@type": "HowToStep", "text": "text"}},
+	}
+	with := func(kv ...any) map[string]any {
+		doc := map[string]any{}
+		for k, v := range valid {
+			doc[k] = v
+		}
+		for i := 0; i < len(kv); i += 2 {
+			if kv[i+1] == nil {
+				delete(doc, kv[i].(string))
+				continue
+			}
+			doc[kv[i].(string)] = kv[i+1]
+		}
+		return doc
+	}
+	for i, tc := range []struct {
+		doc    map[string]any
+		issues []Issue
+	}{
+		{valid, nil},
+		{with("@type", "HowTo"), []Issue{
+			{"@type", true, "is not a LabProtocol: HowTo"}}},
+		{with("name", " ", "license", nil), []Issue{
+			{"name", true, "required property is missing"},
+			{"license", false, "recommended property is missing"}}},
+		{with("dct:conformsTo", map[string]any{"@id": "https://schema.org/HowTo"}), []Issue{
+			{"dct:conformsTo", true, `does not refer to the LabProtocol profile: "https://schema.org/HowTo"`}}},
+		{with("author", map[string]any{"name": "A. Author", "identifier": "0000-0001"}), []Issue{
+			{"author[0]", false, `identifier is not an ORCID URL: "0000-0001"`}}},
+		{with("supply", []any{
+			map[string]any{"@type": "HowToSupply", "@id": "#material-1", "name": "Agarose"},
+			map[string]any{"@type": "Thing", "name": "Buffer"}},
+			"bioschemas:reagentUsed", []any{
+				map[string]any{"@id": "#material-1"},
+				map[string]any{"@id": "#material-2"}}), []Issue{
+			{"supply[1]", true, "supply must be a HowToSupply with a name"},
+			{"bioschemas:reagentUsed[1]", false, `does not refer to a supply: "#material-2"`}}},
+		{with("step", []any{
+			map[string]any{"@type": "HowToSection", "name": "Prepare"},
+			map[string]any{"@type": "HowToSection", "itemListElement": []any{
+				map[string]any{"@type": "HowToStep"}}},
+			map[string]any{"@type": "Thing"}}), []Issue{
+			{"step[0]", true, "section contains no steps"},
+			{"step[1]", false, "section has no name"},
+			{"step[1].itemListElement[0]", false, "step has no text"},
+			{"step[2]", true, "step must be a HowToStep or HowToSection"}}},
+	} {
+		buf, err := json.Marshal(tc.doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		issues, err := ValidateLabProtocol(buf)
+		if err != nil {
+			t.Errorf("%v: %v", i, err)
+			continue
+		}
+		if got, want := issues, tc.issues; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", i, got, want)
+		}
+		if got, want := HasErrors(issues), HasErrors(tc.issues); got != want {
+			t.Errorf("%v: got %v, want %v", i, got, want)
+		}
+	}
+	if _, err := ValidateLabProtocol([]byte("not json")); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestISODuration(t *testing.T) {
+	for i, tc := range []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, ""},
+		{-time.Second, ""},
+		{90 * time.Second, "PT1M30S"},
+		{time.Hour + 5*time.Minute + 30*time.Second, "PT1H5M30S"},
+		{26 * time.Hour, "PT26H"},
+	} {
+		if got, want := isoDuration(tc.d), tc.want; got != want {
+			t.Errorf("%v: got %v, want %v", i, got, want)
+		}
+	}
+}
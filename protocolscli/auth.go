@@ -0,0 +1,50 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cosnicolaou/protocolsio/api"
+)
+
+type AuthLoginFlags struct {
+	State string `subcmd:"state,,'optional state parameter to include in the authorization URL'"`
+}
+
+type AuthLogoutFlags struct{}
+
+func authLoginCmd(ctx context.Context, values interface{}, args []string) error {
+	fv := values.(*AuthLoginFlags)
+	oauth := globalConfig.OAuth2Config()
+	if len(oauth.ClientID) == 0 || len(oauth.ClientSecret) == 0 {
+		return fmt.Errorf("public_clientid and public_secret must be set in the config file")
+	}
+	if len(args) == 0 {
+		fmt.Printf("visit the following URL to authorize this client and then rerun this command with the returned code as its argument:\n\n%v\n", oauth.AuthCodeURL(fv.State))
+		return nil
+	}
+	tok, err := oauth.Exchange(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	cache := globalConfig.TokenCachePath()
+	if err := api.WriteTokenFile(cache, tok); err != nil {
+		return err
+	}
+	fmt.Printf("token written to %v\n", cache)
+	return nil
+}
+
+func authLogoutCmd(ctx context.Context, values interface{}, args []string) error {
+	cache := globalConfig.TokenCachePath()
+	if err := os.Remove(cache); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -5,18 +5,28 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 
 	"cloudeng.io/cmdutil/flags"
 	"cloudeng.io/errors"
 	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/store"
 )
 
+// checkpointFilename is the name of the file, in the cache directory,
+// that records the progress of the most recent download.
+const checkpointFilename = "checkpoint.json"
+
 type checkpoint struct {
-	CurrentPage int // Used for naming the checkpoint file
-	TotalPages  int // Used for naming the checkpoint file
+	CurrentPage int // Most recently downloaded page.
+	TotalPages  int // Total number of pages reported by the server.
 	// The following are set originally from command line flags.
 	Pages      flags.IntRangeSpec // Range of pages equested, eg. 1-
 	PageSize   int                // Number of itmes per page.
@@ -24,9 +34,13 @@ type checkpoint struct {
 	FieldOrder string             //
 	Order      string             //
 	Total      int                // Total number of protocols to download.
+	Query      string             // Search query, if any.
+	QueryName  string             // Name under which query results are saved.
 
-	// Pagination returned by API server, used to resume the download.
+	// Pagination returned by API server.
 	Pagination api.Pagination
+	// Resume records the progress of the download so that it can be resumed.
+	Resume api.ResumeToken
 	// Names of files this checkpoint covers.
 	Files []string
 }
@@ -36,6 +50,9 @@ func newCheckpointFromFlags(fv *ProtocolsListFlags) (checkpoint, error) {
 	errs.Append(flags.OneOf(fv.Filter).Validate("public", ProtocolListFilters()...))
 	errs.Append(flags.OneOf(fv.Order).Validate("activity", ProtocolListOrderField()...))
 	errs.Append(flags.OneOf(fv.Sort).Validate("asc", "desc"))
+	if fv.Order == "relevance" && len(strings.TrimSpace(fv.Query)) == 0 {
+		errs.Append(fmt.Errorf("--order=relevance requires a search query to be specified via --query"))
+	}
 	if err := errs.Err(); err != nil {
 		return checkpoint{}, err
 	}
@@ -48,6 +65,7 @@ func newCheckpointFromFlags(fv *ProtocolsListFlags) (checkpoint, error) {
 		FieldOrder:  fv.Order,
 		Order:       fv.Sort,
 		Total:       fv.Total,
+		Query:       fv.Query,
 	}, nil
 }
 
@@ -56,6 +74,9 @@ func (cp *checkpoint) initHeaders(v *url.Values) {
 	v.Add("filter", string(cp.Filter))
 	v.Add("field_order", string(cp.FieldOrder))
 	v.Add("order", string(cp.Order))
+	if len(cp.Query) > 0 {
+		v.Add("key", cp.Query)
+	}
 	v.Set("page_id", strconv.Itoa(cp.Pages.From))
 }
 
@@ -67,30 +88,119 @@ func (cp *checkpoint) appendFile(file string) {
 	cp.Files = append(cp.Files, file)
 }
 
-func (cp *checkpoint) update(p api.Pagination) (bool, int, error) {
+// paginateOptions returns the options required to start, or resume,
+// iterating over the pages specified by the checkpoint.
+func (cp *checkpoint) paginateOptions() api.PaginateOptions {
+	opts := api.PaginateOptions{
+		FirstPage: cp.Pages.From,
+		LastPage:  cp.Pages.To,
+		MaxItems:  cp.Total,
+		PageSize:  cp.PageSize,
+	}
+	if cp.Pages.To == 0 && !cp.Pages.ExtendsToEnd {
+		opts.LastPage = cp.Pages.From
+	}
+	if cp.Resume.NextPage > 0 || cp.Resume.Done {
+		rt := cp.Resume
+		opts.Resume = &rt
+	}
+	return opts
+}
+
+func (cp *checkpoint) update(pg *api.Paginator[json.RawMessage]) {
+	p := pg.Pagination()
 	cp.CurrentPage = int(p.CurrentPage)
+	if cp.CurrentPage == 0 {
+		cp.CurrentPage = pg.PageID()
+	}
 	cp.TotalPages = int(p.TotalPages)
-	done := p.Done()
-	if done {
-		return done, 0, nil
+	cp.Pagination = p
+	cp.Resume = pg.ResumeToken()
+}
+
+// legacyCheckpointPattern matches the per-page checkpoint files written
+// by earlier versions.
+const legacyCheckpointPattern = "checkpoint_[0-9]*_[0-9]*"
+
+func readCheckpoint(filename string) (checkpoint, error) {
+	var cp checkpoint
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return cp, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, fmt.Errorf("failed to decode checkpoint file: %v: %v", filename, err)
+	}
+	return cp, nil
+}
+
+func writeCheckpoint(dir string, cp checkpoint) error {
+	buf, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
 	}
-	u, err := url.Parse(p.NextPage)
+	return store.WriteFileAtomic(filepath.Join(dir, checkpointFilename), buf, 0600)
+}
+
+// latestCheckpoint returns the checkpoint stored in dir, if any. Any
+// per-page checkpoint files written by earlier versions are compacted
+// into a single checkpoint file, using the most recent of them, when no
+// such file exists, and are then removed.
+func latestCheckpoint(dir string) (checkpoint, bool, error) {
+	legacy, err := filepath.Glob(filepath.Join(dir, legacyCheckpointPattern))
 	if err != nil {
-		return done, 0, err
+		return checkpoint{}, false, err
 	}
-	np := u.Query().Get("page_id")
-	if len(np) == 0 {
-		return done, 0, fmt.Errorf("%v: failed to find page_id parameter in %v: %#v", p.NextPage, u.String(), p)
+	filename := filepath.Join(dir, checkpointFilename)
+	_, err = os.Stat(filename)
+	switch {
+	case err == nil:
+	case errors.Is(err, os.ErrNotExist) && len(legacy) > 0:
+		// The legacy filenames are zero padded and hence sort by page.
+		sort.Strings(legacy)
+		cp, err := readCheckpoint(legacy[len(legacy)-1])
+		if err != nil {
+			return checkpoint{}, false, err
+		}
+		if err := writeCheckpoint(dir, cp); err != nil {
+			return checkpoint{}, false, err
+		}
+		fmt.Printf("compacted %v checkpoint files into %v\n", len(legacy), filename)
+	case errors.Is(err, os.ErrNotExist):
+		return checkpoint{}, false, nil
+	default:
+		return checkpoint{}, false, err
 	}
-	npi, err := strconv.Atoi(np)
+	cp, err := readCheckpoint(filename)
 	if err != nil {
-		return done, 0, fmt.Errorf("failed to parse %q: %v", np, err)
+		return checkpoint{}, false, err
 	}
-	cp.Pages.From = npi
-	cp.Pagination = p
-	return done, npi, err
+	for _, f := range legacy {
+		if err := os.Remove(f); err != nil {
+			return checkpoint{}, false, err
+		}
+	}
+	return cp, true, nil
 }
 
-func (cp *checkpoint) filename() string {
-	return fmt.Sprintf("checkpoint_%05v_%05v", cp.CurrentPage, cp.TotalPages)
+// compatible returns an error describing the differences between the
+// query parameters used to create cp and those of requested, if any.
+func (cp checkpoint) compatible(requested checkpoint) error {
+	var diffs []string
+	diff := func(name string, prev, cur any) {
+		if prev != cur {
+			diffs = append(diffs, fmt.Sprintf("%v: %v != %v", name, prev, cur))
+		}
+	}
+	diff("--filter", cp.Filter, requested.Filter)
+	diff("--order", cp.FieldOrder, requested.FieldOrder)
+	diff("--sort", cp.Order, requested.Order)
+	diff("--size", cp.PageSize, requested.PageSize)
+	diff("--query", cp.Query, requested.Query)
+	diff("--pages", cp.Pages.String(), requested.Pages.String())
+	diff("--total", cp.Total, requested.Total)
+	if len(diffs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("checkpoint was created with different flags (checkpoint != current): %v", strings.Join(diffs, ", "))
 }
@@ -5,11 +5,14 @@
 package main
 
 import (
-	"context"
 	"errors"
 	"fmt"
+	"log"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/cosnicolaou/protocolsio/api"
 	"gopkg.in/yaml.v3"
@@ -20,10 +23,20 @@ type Config struct {
 		PublicToken  string `yaml:"public_token"`
 		ClientID     string `yaml:"public_clientid"`
 		ClientSecret string `yaml:"public_secret"`
+		AuthURL      string `yaml:"auth_url"`
+		TokenURL     string `yaml:"token_url"`
+		RedirectURL  string `yaml:"redirect_url"`
+		TokenCache   string `yaml:"token_cache"`
 	}
 	Cache struct {
-		Path string `yaml:"path"`
+		Path    string `yaml:"path"`
+		Backend string `yaml:"backend"` // filesystem or kv, see store.Open.
 	}
+	RateLimit struct {
+		RequestsPerMinute int    `yaml:"requests_per_minute"`
+		Burst             int    `yaml:"burst"`
+		StateFile         string `yaml:"state_file"`
+	} `yaml:"rate_limit"`
 	Endpoints struct {
 		ListProtocolsV3 string `yaml:"list_protocols_v3"`
 		GetProtocolV4   string `yaml:"get_protocol_v4"`
@@ -36,11 +49,114 @@ func (c *Config) String() string {
 	if len(c.Auth.PublicToken) > 0 {
 		fmt.Fprintf(&out, "  token: **redacted**\n")
 	}
+	if len(c.Auth.ClientID) > 0 {
+		fmt.Fprintf(&out, "  clientid: %v\n", c.Auth.ClientID)
+	}
+	if len(c.Auth.ClientSecret) > 0 {
+		fmt.Fprintf(&out, "  secret: **redacted**\n")
+	}
+	fmt.Fprintf(&out, "  token cache: %v\n", c.TokenCachePath())
 	return out.String()
 }
 
-func (c *Config) WithAuth(ctx context.Context) context.Context {
-	return api.WithPublicToken(ctx, c.Auth.PublicToken)
+// NewClient returns an api.Client configured according to the
+// endpoints and authentication information in the config file.
+func (c *Config) NewClient(opts ...api.Option) *api.Client {
+	copts := []api.Option{
+		api.WithEndpoints(api.Endpoints{
+			ListProtocolsV3: c.Endpoints.ListProtocolsV3,
+			GetProtocolV4:   c.Endpoints.GetProtocolV4,
+		}),
+		api.WithAuth(c.Authorizer()),
+		api.WithLogger(log.New(os.Stdout, "", 0)),
+		api.WithRetryHook(logRetry),
+		api.WithRateLimiter(c.RateLimiter()),
+	}
+	return api.NewClient(append(copts, opts...)...)
+}
+
+// Defaults for the rate_limit section of the config file.
+const (
+	defaultRequestsPerMinute = 100
+	defaultBurst             = 10
+)
+
+// RateLimiter returns the process-wide rate limiter specified by the
+// rate_limit section of the config file. A negative requests_per_minute
+// disables rate limiting.
+func (c *Config) RateLimiter() api.RateLimiter {
+	rpm, burst := c.RateLimit.RequestsPerMinute, c.RateLimit.Burst
+	switch {
+	case rpm < 0:
+		return api.NewRateLimiter(0)
+	case rpm == 0:
+		rpm = defaultRequestsPerMinute
+	}
+	if burst == 0 {
+		burst = defaultBurst
+	}
+	var opts []api.TokenBucketOption
+	if len(c.RateLimit.StateFile) > 0 {
+		opts = append(opts, api.WithStateFile(os.ExpandEnv(c.RateLimit.StateFile)))
+	}
+	return api.NewTokenBucket(rpm, burst, opts...)
+}
+
+func logRetry(ev api.RetryEvent) {
+	reason := http.StatusText(ev.StatusCode)
+	if ev.Err != nil {
+		reason = ev.Err.Error()
+	}
+	fmt.Printf("%v: attempt %v failed: %v: retrying in %v\n", ev.URL, ev.Attempt, reason, ev.Delay.Round(time.Millisecond))
+}
+
+// OAuth2Config returns the OAuth2 configuration specified in the
+// config file.
+func (c *Config) OAuth2Config() api.OAuth2Config {
+	return api.OAuth2Config{
+		ClientID:     c.Auth.ClientID,
+		ClientSecret: c.Auth.ClientSecret,
+		AuthURL:      c.Auth.AuthURL,
+		TokenURL:     c.Auth.TokenURL,
+		RedirectURL:  c.Auth.RedirectURL,
+	}
+}
+
+// TokenCachePath returns the file used to cache OAuth2 tokens,
+// which defaults to $HOME/.protocolsio-token.json.
+func (c *Config) TokenCachePath() string {
+	if len(c.Auth.TokenCache) > 0 {
+		return os.ExpandEnv(c.Auth.TokenCache)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".protocolsio-token.json"
+	}
+	return filepath.Join(home, ".protocolsio-token.json")
+}
+
+// Authorizer returns the api.Authorizer to use. A user token
+// previously obtained via 'auth login' is preferred, followed by the
+// client credentials flow if a client id and secret are configured,
+// and finally the public token. A cached token that cannot be refreshed
+// is only used until it expires.
+func (c *Config) Authorizer() api.Authorizer {
+	oauth := c.OAuth2Config()
+	cache := c.TokenCachePath()
+	tok, err := api.ReadTokenFile(cache)
+	if err == nil && len(tok.RefreshToken) > 0 {
+		return api.TokenAuthorizer(
+			api.CachedTokenSource(cache, oauth.TokenSource(tok)))
+	}
+	if len(c.Auth.ClientID) > 0 && len(c.Auth.ClientSecret) > 0 {
+		return api.TokenAuthorizer(
+			api.CachedTokenSource(cache, oauth.ClientCredentials()))
+	}
+	if err == nil && tok.Valid() {
+		return api.TokenAuthorizer(
+			api.CachedTokenSource(cache, oauth.TokenSource(tok)))
+	}
+	return api.PublicToken(c.Auth.PublicToken)
 }
 
 func ParseConfig(file string) (*Config, error) {
@@ -0,0 +1,47 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cosnicolaou/protocolsio/api"
+)
+
+func TestAuthorizer(t *testing.T) {
+	expired := time.Now().Add(-time.Hour)
+	for i, tc := range []struct {
+		tok          *api.Token
+		clientSecret string
+		public       bool
+	}{
+		{nil, "", true},
+		{nil, "secret", false},
+		{&api.Token{AccessToken: "a"}, "", false},
+		{&api.Token{AccessToken: "a", Expiry: time.Now().Add(time.Hour)}, "", false},
+		{&api.Token{AccessToken: "a", RefreshToken: "r", Expiry: expired}, "", false},
+		// Expired tokens that cannot be refreshed are not used.
+		{&api.Token{AccessToken: "a", Expiry: expired}, "", true},
+		{&api.Token{AccessToken: "a", Expiry: expired}, "secret", false},
+	} {
+		cfg := &Config{}
+		cfg.Auth.PublicToken = "public"
+		cfg.Auth.TokenCache = filepath.Join(t.TempDir(), "token.json")
+		if len(tc.clientSecret) > 0 {
+			cfg.Auth.ClientID, cfg.Auth.ClientSecret = "id", tc.clientSecret
+		}
+		if tc.tok != nil {
+			if err := api.WriteTokenFile(cfg.Auth.TokenCache, *tc.tok); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, public := cfg.Authorizer().(api.PublicToken)
+		if got, want := public, tc.public; got != want {
+			t.Errorf("%v: got %v, want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"cloudeng.io/cmdutil/flags"
+	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/diff"
+	"github.com/cosnicolaou/protocolsio/store"
+)
+
+type ProtocolsDiffFlags struct {
+	CacheFlags
+	Format string `subcmd:"format,text,'output format, one of text or json'"`
+}
+
+func protocolsDiffCmd(ctx context.Context, values interface{}, args []string) error {
+	fv := values.(*ProtocolsDiffFlags)
+	if err := flags.OneOf(fv.Format).Validate("text", "text", "json"); err != nil {
+		return err
+	}
+	if len(args) > 3 {
+		return fmt.Errorf("too many arguments: expected: <id> [v1] [v2]")
+	}
+	nums := make([]int64, len(args))
+	for i, arg := range args {
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid protocol id or version: %q: %v", arg, err)
+		}
+		nums[i] = n
+	}
+	dir, err := fv.cachePath()
+	if err != nil {
+		return err
+	}
+	st, err := openStoreReadOnly(dir)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	id := nums[0]
+	versions, err := st.Versions(ctx, id, store.Detail)
+	if err != nil {
+		return err
+	}
+	// By default, compare the two most recent versions, or the
+	// specified version with the most recent one.
+	var v1, v2 int
+	switch len(nums) {
+	case 1:
+		if len(versions) < 2 {
+			return fmt.Errorf("%v: %v version(s) in the cache, at least two are required", id, len(versions))
+		}
+		v1, v2 = versions[len(versions)-2], versions[len(versions)-1]
+	case 2:
+		if len(versions) == 0 {
+			return fmt.Errorf("%v: %w", store.Key{ID: id, Kind: store.Detail}.Name(), store.ErrNotFound)
+		}
+		v1, v2 = int(nums[1]), versions[len(versions)-1]
+	case 3:
+		v1, v2 = int(nums[1]), int(nums[2])
+	}
+	old, err := readCachedProtocol(ctx, st, id, v1)
+	if err != nil {
+		return err
+	}
+	new, err := readCachedProtocol(ctx, st, id, v2)
+	if err != nil {
+		return err
+	}
+	d := diff.Protocols(old, new)
+	if fv.Format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	}
+	return d.WriteText(os.Stdout)
+}
+
+func readCachedProtocol(ctx context.Context, st store.Store, id int64, version int) (api.Protocol, error) {
+	buf, err := st.Get(ctx, store.Key{ID: id, Kind: store.Detail, Version: version})
+	if err != nil {
+		return api.Protocol{}, err
+	}
+	p, err := store.ParseProtocol(store.Detail, buf)
+	if err != nil {
+		return api.Protocol{}, fmt.Errorf("%v: version %v: %v", id, version, err)
+	}
+	return p, nil
+}
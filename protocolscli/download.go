@@ -12,104 +12,292 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"cloudeng.io/errors"
 	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/store"
 )
 
 type ProtocolsDownloadFlags struct {
 	ProtocolsListFlags
-	CacheDir       string `subcmd:"cachepath,,'location of cache of download protocol objects that overides that specified in the global yaml config'"`
-	CheckpointFile string `subcmd:"resume,,checkpoint file to resume download from"`
+	CacheLockFlags
+	CacheFlags
+	CheckpointFile string `subcmd:"resume,,'checkpoint file to resume download from, by default the download is resumed from the checkpoint in the cache directory if its flags match the current ones'"`
+	Fresh          bool   `subcmd:"fresh,false,'ignore any checkpoint in the cache directory and start a new download'"`
+	Concurrency    int    `subcmd:"concurrency,4,'number of protocol details to fetch concurrently, all fetches share the rate limit set in the config file'"`
 }
 
 func protocolsDownloadCmd(ctx context.Context, values interface{}, args []string) error {
 	fv := values.(*ProtocolsDownloadFlags)
-	dir := fv.CacheDir
-	if len(dir) == 0 {
-		dir = globalConfig.Cache.Path
-	}
-	if len(dir) == 0 {
-		return fmt.Errorf("no cache path specified either via --cachepath or via the global yaml config file")
-	}
-	saver, err := newItemSaver(dir)
+	dir, err := cachePath(fv.CacheDir)
 	if err != nil {
 		return err
 	}
 	var cp checkpoint
 	if len(fv.CheckpointFile) != 0 {
-		data, err := os.ReadFile(fv.CheckpointFile)
+		cp, err = readCheckpoint(fv.CheckpointFile)
 		if err != nil {
-			return fmt.Errorf("failed to read checkpoint: %v", err)
-		}
-		if err := json.Unmarshal(data, &cp); err != nil {
-			return fmt.Errorf("failed to decode checkpoint file: %v: %v", fv.CheckpointFile, err)
+			return err
 		}
 	} else {
 		cp, err = newCheckpointFromFlags(&fv.ProtocolsListFlags)
 		if err != nil {
 			return err
 		}
+		switch {
+		case len(cp.Query) > 0:
+			cp.QueryName = fv.QueryName
+			if len(cp.QueryName) == 0 {
+				cp.QueryName = queryName(cp.Query)
+			}
+		case len(fv.QueryName) > 0:
+			return fmt.Errorf("--query-name requires --query")
+		}
+	}
+	dir = queryDir(dir, cp.QueryName)
+	lock, err := lockCache(ctx, dir, fv.Wait)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	if len(fv.CheckpointFile) == 0 && !fv.Fresh {
+		cp, err = resumeFromCheckpoint(dir, cp)
+		if err != nil {
+			return err
+		}
+	}
+	saver, err := newItemSaver(dir, fv.Concurrency)
+	if err != nil {
+		return err
 	}
+	defer saver.close()
 	return getProtocols(ctx, cp, saver)
 }
 
+// resumeFromCheckpoint returns the checkpoint in dir if it was created
+// with the same flags as requested and has not completed, or requested
+// otherwise. An error is returned if the checkpoint was created with
+// different flags.
+func resumeFromCheckpoint(dir string, requested checkpoint) (checkpoint, error) {
+	cp, ok, err := latestCheckpoint(dir)
+	if err != nil || !ok {
+		return requested, err
+	}
+	filename := filepath.Join(dir, checkpointFilename)
+	if err := cp.compatible(requested); err != nil {
+		return requested, fmt.Errorf("%v: %v: use --fresh to start a new download, or --resume to resume this one", filename, err)
+	}
+	if cp.Resume.Done {
+		fmt.Printf("%v: previous download completed, starting a new one\n", filename)
+		return requested, nil
+	}
+	fmt.Printf("%v: resuming download from page %v\n", filename, cp.Resume.NextPage)
+	return cp, nil
+}
+
+// cachePath returns the cache directory specified on the command line,
+// if any, or the one specified in the global yaml config file.
+func cachePath(override string) (string, error) {
+	dir := override
+	if len(dir) == 0 {
+		dir = globalConfig.Cache.Path
+	}
+	if len(dir) == 0 {
+		return "", fmt.Errorf("no cache path specified either via --cachepath or via the global yaml config file")
+	}
+	return dir, nil
+}
+
+// CacheFlags select the cache directory, and optionally the results of
+// a saved query within it, used by a command.
+type CacheFlags struct {
+	CacheDir  string `subcmd:"cachepath,,'location of cache of download protocol objects that overides that specified in the global yaml config'"`
+	QueryName string `subcmd:"query-name,,'name of a saved query, see protocols download --query, whose results are to be used rather than those of the cache itself, download defaults it to a name derived from --query'"`
+}
+
+// cachePath returns the directory to be used for the cache, or for
+// the saved query if one is specified.
+func (cf CacheFlags) cachePath() (string, error) {
+	dir, err := cachePath(cf.CacheDir)
+	if err != nil {
+		return "", err
+	}
+	return queryDir(dir, cf.QueryName), nil
+}
+
+// queryDir returns the directory, within the cache directory dir, used
+// for the results of the named query, or dir if name is empty.
+func queryDir(dir, name string) string {
+	if len(name) == 0 {
+		return dir
+	}
+	return filepath.Join(dir, "queries", name)
+}
+
+// queryName returns a name, suitable for use as a directory name,
+// derived from the supplied search query.
+func queryName(query string) string {
+	var out strings.Builder
+	sep := false
+	for _, r := range strings.ToLower(query) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if sep && out.Len() > 0 {
+				out.WriteByte('-')
+			}
+			out.WriteRune(r)
+			sep = false
+			continue
+		}
+		sep = true
+	}
+	if out.Len() == 0 {
+		return "query"
+	}
+	return out.String()
+}
+
 type itemSaver struct {
-	root       string
-	totalItems int
+	root        string
+	store       store.Store
+	concurrency int
+	totalItems  int
+
+	failures *failureLedger
+
+	mu          sync.Mutex
+	unavailable int // protocols that are not found, private or removed.
+	failed      int
+}
+
+// openStore opens the store in dir using the backend specified in the
+// global yaml config file. The caller must hold the cache lock.
+func openStore(dir string) (store.Store, error) {
+	return store.Open(globalConfig.Cache.Backend, dir)
 }
 
-func newItemSaver(dir string) (protocolItemProcessor, error) {
+// openStoreReadOnly opens the store in dir for reading only, it does
+// not require the cache lock.
+func openStoreReadOnly(dir string) (store.Store, error) {
+	return store.OpenReadOnly(globalConfig.Cache.Backend, dir)
+}
+
+func newItemSaver(dir string, concurrency int) (*itemSaver, error) {
 	if err := os.MkdirAll(dir, 0700); err != nil {
 		return nil, err
 	}
-	return &itemSaver{dir, 0}, nil
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	failures, err := readFailureLedger(dir)
+	if err != nil {
+		return nil, err
+	}
+	st, err := openStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &itemSaver{
+		root:        dir,
+		store:       st,
+		concurrency: concurrency,
+		failures:    failures,
+	}, nil
+}
+
+func (is *itemSaver) close() error {
+	return is.store.Close()
 }
 
-func (is *itemSaver) encodeAndWrite(enc *json.Encoder, buf *bytes.Buffer, item any, filename string) error {
+func (is *itemSaver) encodeAndWrite(ctx context.Context, enc *json.Encoder, buf *bytes.Buffer, item any, key store.Key) error {
 	buf.Reset()
-	file := filepath.Join(is.root, filename)
 	if err := enc.Encode(item); err != nil {
-		fmt.Printf("%s: encode error: %v\n", file, err)
+		fmt.Printf("%s: encode error: %v\n", key.Name(), err)
 		return err
 	}
-	return is.write(buf.Bytes(), filename)
+	return is.write(ctx, buf.Bytes(), key)
 }
 
-func (is *itemSaver) write(buf []byte, filename string) error {
-	file := filepath.Join(is.root, filename)
-	err := os.WriteFile(file, buf, 0600)
-	if err != nil {
-		fmt.Printf("%s: write error: %v\n", file, err)
+func (is *itemSaver) write(ctx context.Context, buf []byte, key store.Key) error {
+	if err := is.store.Put(ctx, key, buf); err != nil {
+		fmt.Printf("%s: write error: %v\n", key.Name(), err)
 		return err
 	}
-	fmt.Printf("%s (%v)\n", file, is.totalItems)
+	fmt.Printf("%s (%v)\n", filepath.Join(is.root, key.Name()), is.totalItems)
 	return nil
 }
 
-func (is *itemSaver) fileVersion(filename string) (int, bool, error) {
-	file := filepath.Join(is.root, filename)
-	buf, err := os.ReadFile(file)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return 0, false, nil
-		}
-		fmt.Printf("%s: read error: %v\n", file, err)
-		return 0, false, err
+// storedDetail describes the details of a protocol in the store.
+type storedDetail struct {
+	version int
+	changed time.Time
+}
+
+// current returns true if the stored details are for a later version
+// than that specified, or for the same version and have not been changed
+// since. The change time is ignored if the stored details do not have
+// one.
+func (sd storedDetail) current(version int, changed time.Time) bool {
+	if sd.version != version {
+		return sd.version > version
 	}
-	protocol, err := api.ParsePayload[api.Protocol](buf)
+	return sd.changed.IsZero() || !changed.After(sd.changed)
+}
+
+// storedDetail returns the version and change time of the protocol's
+// details in the store and whether they exist. Details that are corrupt,
+// that is, they fail the store's integrity check or cannot be parsed,
+// are treated as not existing so that they are downloaded again.
+func (is *itemSaver) storedDetail(ctx context.Context, id int64) (storedDetail, bool, error) {
+	key := store.Key{ID: id, Kind: store.Detail}
+	buf, err := is.store.Get(ctx, key)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return storedDetail{}, false, nil
+	case errors.Is(err, store.ErrCorrupt):
+		fmt.Printf("%s: [corrupt] (%v)\n", key.Name(), err)
+		return storedDetail{}, false, nil
+	case err != nil:
+		fmt.Printf("%s: read error: %v\n", key.Name(), err)
+		return storedDetail{}, false, err
+	}
+	p, err := store.ParseProtocol(store.Detail, buf)
 	if err != nil {
-		fmt.Printf("%s: decode error: %v\n", file, err)
-		return 0, true, err
+		fmt.Printf("%s: [corrupt] (decode error: %v)\n", key.Name(), err)
+		return storedDetail{}, false, nil
 	}
-	return protocol.VersionID, true, nil
+	return storedDetail{version: p.VersionID, changed: p.ChangedOn}, true, nil
+}
+
+// detailRequest represents a protocol whose details may need to be
+// fetched.
+type detailRequest struct {
+	id      int64
+	version int
+	changed time.Time // the change time of the listed protocol, if known.
+	file    string
+	force   bool // fetch the details even if the cached version is current.
 }
 
 func (is *itemSaver) Process(ctx context.Context, protocols api.ListProtocolsV3, cp checkpoint) error {
 	cp.resetFiles()
-	errs := errors.M{}
+	if err := is.save(ctx, protocols, &cp); err != nil {
+		return err
+	}
+	// only write the checkpoint if every download operation completed successfully.
+	return writeCheckpoint(is.root, cp)
+}
+
+// save writes the .list file for each of the supplied protocols and
+// fetches their details as required.
+func (is *itemSaver) save(ctx context.Context, protocols api.ListProtocolsV3, cp *checkpoint) error {
+	errs := &errors.M{}
 	buf := &bytes.Buffer{}
 	enc := json.NewEncoder(buf)
+	var details []detailRequest
 	for _, item := range protocols.Items {
 		is.totalItems++
 		var p api.Protocol
@@ -117,50 +305,129 @@ func (is *itemSaver) Process(ctx context.Context, protocols api.ListProtocolsV3,
 			errs.Append(err)
 			continue
 		}
-		filebase := fmt.Sprintf("%06d", p.ID)
-		file := filebase + ".list"
-		cp.appendFile(file)
-		tmp := struct {
-			Extras json.RawMessage
-			Item   json.RawMessage
-		}{
+		key := store.Key{ID: p.ID, Kind: store.List, Version: p.VersionID}
+		cp.appendFile(key.Name())
+		entry := store.ListEntry{
 			Extras: protocols.Extras,
 			Item:   item,
 		}
-		if err := is.encodeAndWrite(enc, buf, tmp, file); err != nil {
+		if err := is.encodeAndWrite(ctx, enc, buf, entry, key); err != nil {
 			errs.Append(err)
 			continue
 		}
+		details = append(details, detailRequest{
+			id:      p.ID,
+			version: p.VersionID,
+			changed: p.ChangedOn,
+			file:    store.Key{ID: p.ID, Kind: store.Detail}.Name(),
+		})
+	}
 
-		// Fetch the protocol if it has not already been downloaded
-		// or there's a newer version.
-		file = filebase + ".detail"
-		version, exists, err := is.fileVersion(file)
-		if err != nil {
-			errs.Append(err)
-			continue
-		}
-		if exists && version >= p.VersionID {
-			fmt.Printf("%v: [current] (%v >= %v)\n", file, version, p.VersionID)
-			continue
-		}
-		fmt.Printf("%v: [new] (%v, %v < %v)\n", file, exists, version, p.VersionID)
-		// Issue a get for this individual protocol since the
-		// protocol struct returned by List is incomplete, in particular
-		// it does not contain the description field.
-		_, body, err := getProtocol(ctx, strconv.Itoa(int(p.ID)))
-		if err != nil {
-			errs.Append(err)
-			continue
-		}
-		if err := is.write(body, file); err != nil {
-			errs.Append(err)
-			continue
+	errs.Append(is.fetchDetails(ctx, details))
+	return errs.Err()
+}
+
+// fetchDetails fetches the details for the supplied protocols
+// concurrently. Protocols that fail to download are recorded in the
+// failures ledger rather than being returned as errors so that the
+// download as a whole can make progress.
+func (is *itemSaver) fetchDetails(ctx context.Context, details []detailRequest) error {
+	errs := &errors.M{}
+	// The client's rate limiter is shared by all of the workers.
+	ch := make(chan detailRequest)
+	var wg sync.WaitGroup
+	wg.Add(is.concurrency)
+	for i := 0; i < is.concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for req := range ch {
+				errs.Append(is.fetchDetail(ctx, req))
+			}
+		}()
+	}
+	for _, req := range details {
+		ch <- req
+	}
+	close(ch)
+	wg.Wait()
+	errs.Append(is.failures.save())
+
+	is.mu.Lock()
+	if is.failed > 0 || errs.Err() != nil {
+		fmt.Printf("failed: %v, unavailable: %v, total: %v, see %v\n", is.failed, is.unavailable, is.totalItems, is.failures.filename)
+	}
+	is.mu.Unlock()
+	return errs.Err()
+}
+
+// fetchDetail fetches the protocol if it has not already been
+// downloaded or there's a newer version, or the same version has
+// since been changed.
+func (is *itemSaver) fetchDetail(ctx context.Context, req detailRequest) error {
+	stored, exists, err := is.storedDetail(ctx, req.id)
+	if err != nil {
+		return err
+	}
+	if exists && stored.current(req.version, req.changed) && !req.force {
+		fmt.Printf("%v: [current] (%v >= %v)\n", req.file, stored.version, req.version)
+		is.failures.resolve(req.id)
+		return nil
+	}
+	fmt.Printf("%v: [new] (%v, %v < %v)\n", req.file, exists, stored.version, req.version)
+	// Issue a get for this individual protocol since the
+	// protocol struct returned by List is incomplete, in particular
+	// it does not contain the description field.
+	_, body, err := getProtocol(ctx, strconv.FormatInt(req.id, 10))
+	if err != nil {
+		is.mu.Lock()
+		defer is.mu.Unlock()
+		switch {
+		case ctx.Err() != nil:
+			return err
+		case api.IsUnavailable(err):
+			is.unavailable++
+			is.failures.resolve(req.id)
+			fmt.Printf("%v: [skipped] (%v)\n", req.file, err)
+			return nil
+		case isFatal(err):
+			// Errors that will affect all protocols are not quarantined.
+			return err
 		}
+		is.recordFailure(req, err)
+		return nil
+	}
+	version, err := store.ParseVersion(store.Detail, body)
+	if err == nil && version == 0 {
+		err = fmt.Errorf("no version_id")
+	}
+	if err != nil {
+		// Details that cannot be decoded are not stored so that any
+		// previously downloaded copy is retained, they will be
+		// refetched by the next download or retry-failed.
+		is.mu.Lock()
+		defer is.mu.Unlock()
+		is.recordFailure(req, fmt.Errorf("decode error: %v", err))
+		return nil
 	}
-	if err := errs.Err(); err != nil {
+	if err := is.write(ctx, body, store.Key{ID: req.id, Kind: store.Detail, Version: version}); err != nil {
 		return err
 	}
-	// only write the checkpoint if every download operation completed successfully.
-	return is.encodeAndWrite(enc, buf, cp, cp.filename())
+	is.failures.resolve(req.id)
+	return nil
+}
+
+// recordFailure records a failure to download the details for req in
+// the failures ledger; is.mu must be held.
+func (is *itemSaver) recordFailure(req detailRequest, err error) {
+	is.failed++
+	is.failures.record(req, err)
+	fmt.Printf("%v: [failed] (%v)\n", req.file, err)
+}
+
+// isFatal returns true for errors that are not specific to an
+// individual protocol. A 403 (forbidden) is specific to the protocol
+// being requested and is recorded as a failure for that protocol.
+func isFatal(err error) bool {
+	return errors.Is(err, api.ErrNoCredentials) ||
+		errors.Is(err, api.ErrUnauthorized)
 }
@@ -0,0 +1,35 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStoredDetailCurrent(t *testing.T) {
+	t0 := time.Unix(1600000000, 0)
+	t1 := t0.Add(time.Hour)
+	for i, tc := range []struct {
+		stored  storedDetail
+		version int
+		changed time.Time
+		current bool
+	}{
+		{storedDetail{version: 2, changed: t0}, 2, t0, true},
+		{storedDetail{version: 2, changed: t0}, 1, t1, true},
+		{storedDetail{version: 2, changed: t0}, 3, t0, false},
+		// Same version, changed since it was stored.
+		{storedDetail{version: 2, changed: t0}, 2, t1, false},
+		{storedDetail{version: 2, changed: t1}, 2, t0, true},
+		// Change times that are not known are ignored.
+		{storedDetail{version: 2, changed: t0}, 2, time.Time{}, true},
+		{storedDetail{version: 2}, 2, t1, true},
+	} {
+		if got, want := tc.stored.current(tc.version, tc.changed), tc.current; got != want {
+			t.Errorf("%v: got %v, want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"cloudeng.io/cmdutil/flags"
+	"cloudeng.io/errors"
+	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/export"
+	"github.com/cosnicolaou/protocolsio/store"
+)
+
+type ProtocolsExportFlags struct {
+	CacheFlags
+	All         bool   `subcmd:"all,false,'export all of the protocols in the cache'"`
+	Output      string `subcmd:"output,,'directory to write the exported protocols to, one file per protocol and an index, rather than stdout'"`
+	Images      string `subcmd:"images,link,'one of: link - link to the cached copy of each image; embed - embed the cached copy of each image; remote - link to the image on protocols.io. Images that are not cached are always linked to protocols.io'"`
+	FetchImages bool   `subcmd:"fetch-images,false,'download any images that are not already cached'"`
+	Validate    bool   `subcmd:"validate,false,'validate the jsonld for each protocol against the Bioschemas LabProtocol profile rather than exporting it'"`
+}
+
+// imagesDir is the directory, in the cache, that contains copies of the
+// images referred to by protocols.
+const imagesDir = "images"
+
+func protocolsExportCmd(ctx context.Context, values interface{}, args []string) error {
+	fv := values.(*ProtocolsExportFlags)
+	format, err := export.ParseFormat(args[0])
+	if err != nil {
+		return err
+	}
+	if err := flags.OneOf(fv.Images).Validate("link", "link", "embed", "remote"); err != nil {
+		return err
+	}
+	args = args[1:]
+	switch {
+	case fv.Validate && format != export.JSONLD:
+		return fmt.Errorf("--validate is only supported for jsonld")
+	case fv.All && len(args) > 0:
+		return fmt.Errorf("protocols may not be specified with --all")
+	case !fv.All && len(args) == 0:
+		return fmt.Errorf("no protocols specified, use --all to export all cached protocols")
+	}
+	dir, err := fv.cachePath()
+	if err != nil {
+		return err
+	}
+	st, err := openStoreReadOnly(dir)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	protocols, err := exportedProtocols(ctx, st, dir, fv.All, args)
+	if err != nil {
+		return err
+	}
+	if fv.Validate {
+		return validateLabProtocols(protocols)
+	}
+	images := export.NewImageCache(filepath.Join(dir, imagesDir))
+	if fv.FetchImages {
+		fetchImages(ctx, images, protocols)
+	}
+	outDir := fv.Output
+	if len(outDir) > 0 {
+		if outDir, err = filepath.Abs(outDir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(outDir, 0700); err != nil {
+			return err
+		}
+	}
+	opts := export.Options{Image: imageURL(fv.Images, images, outDir)}
+	if format == export.JSONLD {
+		// JSON-LD documents are published and must refer to images
+		// on protocols.io.
+		opts = export.Options{}
+	}
+	if len(outDir) == 0 {
+		if format == export.JSONLD && len(protocols) > 1 {
+			return export.WriteGraph(os.Stdout, protocols, opts)
+		}
+		errs := errors.M{}
+		for _, p := range protocols {
+			errs.Append(export.Write(os.Stdout, format, p, opts))
+		}
+		return errs.Err()
+	}
+	return exportToDir(outDir, format, protocols, opts)
+}
+
+// validateLabProtocols validates the JSON-LD for each of the protocols
+// and prints any issues found. An error is returned if any of them are
+// missing required properties.
+func validateLabProtocols(protocols []api.Protocol) error {
+	invalid := 0
+	for _, p := range protocols {
+		var buf bytes.Buffer
+		if err := export.Write(&buf, export.JSONLD, p, export.Options{}); err != nil {
+			return err
+		}
+		issues, err := export.ValidateLabProtocol(buf.Bytes())
+		if err != nil {
+			return fmt.Errorf("%v: %v", p.ID, err)
+		}
+		for _, issue := range issues {
+			fmt.Printf("%v: %v\n", p.ID, issue)
+		}
+		if export.HasErrors(issues) {
+			invalid++
+		}
+	}
+	fmt.Printf("validated: %v, invalid: %v\n", len(protocols), invalid)
+	if invalid > 0 {
+		return fmt.Errorf("%v of %v protocols do not conform to %v", invalid, len(protocols), export.LabProtocolProfile)
+	}
+	return nil
+}
+
+// exportedProtocols returns the latest cached details of the specified
+// protocols, or of all cached protocols.
+func exportedProtocols(ctx context.Context, st store.Store, dir string, all bool, args []string) ([]api.Protocol, error) {
+	var protocols []api.Protocol
+	errs := errors.M{}
+	if all {
+		ids, err := st.List(ctx, store.Detail)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			buf, err := st.Get(ctx, store.Key{ID: id, Kind: store.Detail})
+			if err != nil {
+				errs.Append(err)
+				continue
+			}
+			p, err := store.ParseProtocol(store.Detail, buf)
+			if err != nil {
+				errs.Append(fmt.Errorf("%v: %v", id, err))
+				continue
+			}
+			protocols = append(protocols, p)
+		}
+		return protocols, errs.Err()
+	}
+	gc := &getCache{dir: dir, store: st}
+	for _, arg := range args {
+		ref, err := parseProtocolRef(arg)
+		if err != nil {
+			return nil, err
+		}
+		buf, _, err := gc.cached(ctx, ref)
+		if err != nil {
+			errs.Append(err)
+			continue
+		}
+		if buf == nil {
+			errs.Append(fmt.Errorf("%v: not cached, use 'protocols get --save' or 'protocols download' to cache it", ref))
+			continue
+		}
+		p, err := store.ParseProtocol(store.Detail, buf)
+		if err != nil {
+			errs.Append(fmt.Errorf("%v: %v", ref, err))
+			continue
+		}
+		protocols = append(protocols, p)
+	}
+	return protocols, errs.Err()
+}
+
+// fetchImages downloads the images referred to by the protocols that
+// are not already cached. Failures are reported but are otherwise
+// ignored since the exported protocols will link to the original image.
+func fetchImages(ctx context.Context, images *export.ImageCache, protocols []api.Protocol) {
+	for _, p := range protocols {
+		for _, src := range export.ImageSources(p) {
+			if err := images.Fetch(ctx, http.DefaultClient, src); err != nil {
+				fmt.Fprintf(os.Stderr, "%v: failed to fetch image: %v\n", p.ID, err)
+			}
+		}
+	}
+}
+
+// imageURL returns the function used to map the URL of each image to
+// that to be used in the exported protocols. Links to cached images are
+// relative to outDir if it is set.
+func imageURL(mode string, images *export.ImageCache, outDir string) func(string) string {
+	switch mode {
+	case "remote":
+		return nil
+	case "embed":
+		return func(src string) string {
+			if uri, ok := images.DataURI(src); ok {
+				return uri
+			}
+			return src
+		}
+	}
+	return func(src string) string {
+		filename, ok := images.Cached(src)
+		if !ok {
+			return src
+		}
+		if len(outDir) > 0 {
+			if abs, err := filepath.Abs(filename); err == nil {
+				if rel, err := filepath.Rel(outDir, abs); err == nil {
+					return filepath.ToSlash(rel)
+				}
+			}
+		}
+		return filepath.ToSlash(filename)
+	}
+}
+
+// exportToDir writes each protocol to its own file in dir, along with
+// an index of all of the exported protocols.
+func exportToDir(dir string, format export.Format, protocols []api.Protocol, opts export.Options) error {
+	entries := make([]export.IndexEntry, 0, len(protocols))
+	errs := errors.M{}
+	for _, p := range protocols {
+		name := fmt.Sprintf("%06d%s", p.ID, format.Extension())
+		if err := writeExported(filepath.Join(dir, name), func(w io.Writer) error {
+			return export.Write(w, format, p, opts)
+		}); err != nil {
+			errs.Append(err)
+			continue
+		}
+		entries = append(entries, export.IndexEntry{Protocol: p, Filename: name})
+	}
+	index := filepath.Join(dir, "index"+format.Extension())
+	errs.Append(writeExported(index, func(w io.Writer) error {
+		return export.WriteIndex(w, format, "Protocols", entries)
+	}))
+	fmt.Printf("exported %v protocols to %v, see %v\n", len(entries), dir, index)
+	return errs.Err()
+}
+
+// writeExported atomically writes the output of fn to filename, which
+// is only readable by the current user since protocols may be private.
+func writeExported(filename string, fn func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := fn(&buf); err != nil {
+		return fmt.Errorf("%v: %v", filename, err)
+	}
+	return store.WriteFileAtomic(filename, buf.Bytes(), 0600)
+}
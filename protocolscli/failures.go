@@ -0,0 +1,188 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"cloudeng.io/errors"
+	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/store"
+)
+
+const failuresFilename = "failures.json"
+
+// failure records a protocol whose details could not be downloaded.
+type failure struct {
+	ID           int64     `json:"id"`
+	Version      int       `json:"version_id"`
+	File         string    `json:"file"`
+	Error        string    `json:"error"`
+	HTTPStatus   int       `json:"http_status,omitempty"`
+	Attempts     int       `json:"attempts"`
+	FirstAttempt time.Time `json:"first_attempt"`
+	LastAttempt  time.Time `json:"last_attempt"`
+}
+
+// failureLedger records the protocols that failed to download, in the
+// file failures.json in the cache directory, so that a single failure
+// does not prevent a download from making progress and so that the
+// failed protocols can be retried later.
+type failureLedger struct {
+	filename string
+
+	mu       sync.Mutex
+	failures map[int64]*failure
+	changed  bool
+}
+
+func readFailureLedger(dir string) (*failureLedger, error) {
+	fl := &failureLedger{
+		filename: filepath.Join(dir, failuresFilename),
+		failures: map[int64]*failure{},
+	}
+	buf, err := os.ReadFile(fl.filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fl, nil
+		}
+		return nil, err
+	}
+	var failures []*failure
+	if err := json.Unmarshal(buf, &failures); err != nil {
+		return nil, fmt.Errorf("failed to decode failures ledger: %v: %v", fl.filename, err)
+	}
+	for _, f := range failures {
+		fl.failures[f.ID] = f
+	}
+	return fl, nil
+}
+
+// record records a failed attempt to download the specified protocol.
+func (fl *failureLedger) record(req detailRequest, err error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	now := time.Now().UTC()
+	f, ok := fl.failures[req.id]
+	if !ok {
+		f = &failure{ID: req.id, FirstAttempt: now}
+		fl.failures[req.id] = f
+	}
+	f.Version = req.version
+	f.File = req.file
+	f.Error = err.Error()
+	f.HTTPStatus = 0
+	var apiErr *api.APIError
+	if errors.As(err, &apiErr) {
+		f.HTTPStatus = apiErr.HTTPStatus
+	}
+	f.Attempts++
+	f.LastAttempt = now
+	fl.changed = true
+}
+
+// resolve removes the specified protocol from the ledger.
+func (fl *failureLedger) resolve(id int64) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if _, ok := fl.failures[id]; ok {
+		delete(fl.failures, id)
+		fl.changed = true
+	}
+}
+
+// contains returns true if the specified protocol is in the ledger.
+func (fl *failureLedger) contains(id int64) bool {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	_, ok := fl.failures[id]
+	return ok
+}
+
+// list returns the failures ordered by protocol ID.
+func (fl *failureLedger) list() []*failure {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	failures := make([]*failure, 0, len(fl.failures))
+	for _, f := range fl.failures {
+		failures = append(failures, f)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].ID < failures[j].ID
+	})
+	return failures
+}
+
+func (fl *failureLedger) len() int {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return len(fl.failures)
+}
+
+// save writes the ledger if it has changed, removing it if there
+// are no longer any failures.
+func (fl *failureLedger) save() error {
+	failures := fl.list()
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if !fl.changed {
+		return nil
+	}
+	fl.changed = false
+	if len(failures) == 0 {
+		if err := os.Remove(fl.filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	buf, err := json.MarshalIndent(failures, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(fl.filename, buf, 0600)
+}
+
+type ProtocolsRetryFailedFlags struct {
+	CacheLockFlags
+	CacheFlags
+	Concurrency int `subcmd:"concurrency,4,'number of protocol details to fetch concurrently, all fetches share the rate limit set in the config file'"`
+}
+
+func protocolsRetryFailedCmd(ctx context.Context, values interface{}, args []string) error {
+	fv := values.(*ProtocolsRetryFailedFlags)
+	dir, err := fv.cachePath()
+	if err != nil {
+		return err
+	}
+	lock, err := lockCache(ctx, dir, fv.Wait)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	saver, err := newItemSaver(dir, fv.Concurrency)
+	if err != nil {
+		return err
+	}
+	defer saver.close()
+	failures := saver.failures.list()
+	if len(failures) == 0 {
+		fmt.Printf("no failures recorded in %v\n", saver.failures.filename)
+		return nil
+	}
+	reqs := make([]detailRequest, len(failures))
+	for i, f := range failures {
+		reqs[i] = detailRequest{id: f.ID, version: f.Version, file: f.File}
+	}
+	err = saver.fetchDetails(ctx, reqs)
+	fmt.Printf("retried: %v, still failing: %v, unavailable: %v\n", len(reqs), saver.failures.len(), saver.unavailable)
+	return err
+}
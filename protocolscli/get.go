@@ -8,32 +8,343 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 
 	"cloudeng.io/errors"
 	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/store"
 )
 
-type ProtocolsGetFlags struct{}
+type ProtocolsGetFlags struct {
+	CacheLockFlags
+	OutputFlags
+	CacheFlags
+	Refresh bool `subcmd:"refresh,false,'fetch protocols from protocols.io even if a current copy is cached'"`
+	Save    bool `subcmd:"save,false,'save protocols fetched from protocols.io in the cache'"`
+}
 
+// protocolsGetCmd prints the details of the specified protocols, using
+// the cache when it contains a current copy and protocols.io otherwise.
 func protocolsGetCmd(ctx context.Context, values interface{}, args []string) error {
-	errs := errors.M{}
-	for _, id := range args {
-		_, body, err := getProtocol(ctx, id)
+	fv := values.(*ProtocolsGetFlags)
+	refs := make([]protocolRef, 0, len(args))
+	for _, arg := range args {
+		ref, err := parseProtocolRef(arg)
 		if err != nil {
-			errs.Append(err)
+			return err
+		}
+		refs = append(refs, ref)
+	}
+	pf, err := newProtocolFormatter(os.Stdout, fv.OutputFlags, printRawProtocol)
+	if err != nil {
+		return err
+	}
+	gc, err := newGetCache(ctx, fv)
+	if err != nil {
+		return err
+	}
+	defer gc.close()
+	errs := errors.M{}
+	for _, ref := range refs {
+		body, err := gc.get(ctx, ref, fv.Refresh)
+		errs.Append(err)
+		if body == nil {
+			continue
+		}
+		p, err := store.ParseProtocol(store.Detail, body)
+		if err != nil && fv.Format != "text" {
+			errs.Append(fmt.Errorf("%v: decode error: %v", ref, err))
 			continue
 		}
-		fmt.Printf("%s\n", body)
+		errs.Append(pf.write(p, body))
 	}
+	errs.Append(pf.flush())
 	return errs.Err()
 }
 
+// printRawProtocol prints the JSON response returned by protocols.io.
+func printRawProtocol(w io.Writer, p api.Protocol, raw []byte) error {
+	_, err := fmt.Fprintf(w, "%s\n", raw)
+	return err
+}
+
 func getProtocol(ctx context.Context, id string) (json.RawMessage, []byte, error) {
-	ctx = globalConfig.WithAuth(ctx)
-	u := globalConfig.Endpoints.GetProtocolV4 + "/" + id
-	resp, body, err := api.Get[api.Payload](ctx, u)
-	if resp.StatusCode != 0 {
-		return nil, body, fmt.Errorf("unexpected status_code: %v", resp.StatusCode)
+	resp, body, err := globalClient.GetProtocol(ctx, id)
+	if err != nil {
+		return nil, body, err
+	}
+	return resp.Payload, body, nil
+}
+
+// protocolRef identifies a protocol by exactly one of its numeric id,
+// its uri or its DOI.
+type protocolRef struct {
+	ID  int64
+	URI string
+	DOI string // without any resolver prefix, eg. 10.17504/protocols.io.xxx.
+}
+
+const doiPrefix = "10."
+
+// parseProtocolRef parses a numeric protocol id, a protocol uri, a
+// protocols.io URL (eg. https://www.protocols.io/view/<uri>) or a DOI,
+// either bare or as a doi: or doi.org URL.
+func parseProtocolRef(arg string) (protocolRef, error) {
+	arg = strings.TrimSpace(arg)
+	if len(arg) == 0 {
+		return protocolRef{}, fmt.Errorf("empty protocol id")
+	}
+	if id, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		return protocolRef{ID: id}, nil
+	}
+	if doi, ok := normalizeDOI(arg); ok {
+		return protocolRef{DOI: doi}, nil
+	}
+	if !strings.Contains(arg, "://") && !strings.Contains(arg, "/") {
+		return protocolRef{URI: strings.ToLower(arg)}, nil
+	}
+	u, err := url.Parse(arg)
+	if err != nil {
+		return protocolRef{}, fmt.Errorf("invalid protocol id: %q: %v", arg, err)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i, p := range parts {
+		if p == "view" && i+1 < len(parts) && len(parts[i+1]) > 0 {
+			return protocolRef{URI: strings.ToLower(parts[i+1])}, nil
+		}
+	}
+	return protocolRef{}, fmt.Errorf("invalid protocol id: %q: not a protocol id, uri, protocols.io URL or DOI", arg)
+}
+
+// normalizeDOI returns the DOI in s, stripped of any doi: or resolver
+// URL prefix and lowercased since DOIs are case insensitive.
+func normalizeDOI(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.TrimPrefix(s, "doi:")
+	s = strings.TrimPrefix(s, "https://")
+	s = strings.TrimPrefix(s, "http://")
+	s = strings.TrimPrefix(s, "dx.doi.org/")
+	s = strings.TrimPrefix(s, "doi.org/")
+	if !strings.HasPrefix(s, doiPrefix) || !strings.Contains(s, "/") {
+		return "", false
+	}
+	return s, true
+}
+
+// apiID returns the form of the reference accepted by the protocols.io
+// API, which accepts protocol ids, uris and DOIs. DOIs are specified as
+// dx.doi.org/<doi> and must not be escaped, that is, their slashes are
+// part of the request's path.
+func (r protocolRef) apiID() string {
+	switch {
+	case len(r.URI) > 0:
+		return url.PathEscape(r.URI)
+	case len(r.DOI) > 0:
+		return "dx.doi.org/" + r.DOI
+	}
+	return strconv.FormatInt(r.ID, 10)
+}
+
+func (r protocolRef) String() string {
+	switch {
+	case len(r.URI) > 0:
+		return r.URI
+	case len(r.DOI) > 0:
+		return r.DOI
+	}
+	return strconv.FormatInt(r.ID, 10)
+}
+
+// matches returns true if p is the protocol referred to by r.
+func (r protocolRef) matches(p api.Protocol) bool {
+	switch {
+	case len(r.URI) > 0:
+		return strings.EqualFold(p.URI, r.URI) || strings.EqualFold(p.VersionURI, r.URI)
+	case len(r.DOI) > 0:
+		doi, ok := normalizeDOI(p.DOI)
+		return ok && doi == r.DOI
+	}
+	return p.ID == r.ID
+}
+
+// getCache implements the cache lookups, and updates, for protocols get.
+// The cache is optional unless protocols are to be saved in it.
+type getCache struct {
+	dir   string
+	store store.Store
+	lock  *cacheLock
+	save  bool
+	ids   map[string]int64 // uris and DOIs of cached protocols, see resolve.
+}
+
+func newGetCache(ctx context.Context, fv *ProtocolsGetFlags) (*getCache, error) {
+	dir, err := fv.cachePath()
+	if err != nil {
+		if fv.Save {
+			return nil, err
+		}
+		return &getCache{}, nil
+	}
+	gc := &getCache{dir: dir, save: fv.Save}
+	if fv.Save {
+		if gc.lock, err = lockCache(ctx, dir, fv.Wait); err != nil {
+			return nil, err
+		}
+	}
+	if fv.Save {
+		// lockCache has created dir if it did not already exist.
+		gc.store, err = openStore(dir)
+	} else {
+		gc.store, err = openStoreReadOnly(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			// There is no cache yet, all protocols are fetched.
+			return &getCache{}, nil
+		}
+	}
+	if err != nil {
+		gc.close()
+		return nil, err
+	}
+	return gc, nil
+}
+
+func (gc *getCache) close() error {
+	errs := errors.M{}
+	if gc.store != nil {
+		errs.Append(gc.store.Close())
+	}
+	if gc.lock != nil {
+		errs.Append(gc.lock.release())
+	}
+	return errs.Err()
+}
+
+// get returns the details of the referenced protocol from the cache if
+// a current copy exists and refresh is false, or from protocols.io
+// otherwise. If the protocol cannot be fetched and refresh is false,
+// the cached copy, which is out of date, is returned along with an error
+// so that its use is reflected in the command's exit status.
+func (gc *getCache) get(ctx context.Context, ref protocolRef, refresh bool) ([]byte, error) {
+	cached, current, err := gc.cached(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if current && !refresh {
+		return cached, nil
+	}
+	_, body, err := getProtocol(ctx, ref.apiID())
+	if err != nil {
+		if cached == nil || refresh || isFatal(err) || ctx.Err() != nil {
+			return nil, fmt.Errorf("%v: %w", ref, err)
+		}
+		return cached, fmt.Errorf("%v: displayed an out of date cached copy: %w", ref, err)
+	}
+	if gc.save {
+		if err := gc.put(ctx, ref, body); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// cached returns the cached details of the referenced protocol, if any,
+// and whether they are current, that is, not older than the version in
+// the protocol's list entry. Details that are corrupt are ignored.
+func (gc *getCache) cached(ctx context.Context, ref protocolRef) ([]byte, bool, error) {
+	if gc.store == nil {
+		return nil, false, nil
+	}
+	id, ok, err := gc.resolve(ctx, ref)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	buf, err := gc.store.Get(ctx, store.Key{ID: id, Kind: store.Detail})
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return nil, false, nil
+	case errors.Is(err, store.ErrCorrupt):
+		fmt.Fprintf(os.Stderr, "%v: [corrupt] (%v)\n", ref, err)
+		return nil, false, nil
+	case err != nil:
+		return nil, false, err
+	}
+	version, err := store.ParseVersion(store.Detail, buf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: [corrupt] (decode error: %v)\n", ref, err)
+		return nil, false, nil
+	}
+	listed, err := gc.store.Get(ctx, store.Key{ID: id, Kind: store.List})
+	if err != nil {
+		// Protocols fetched via get have no list entry.
+		return buf, true, nil
+	}
+	listedVersion, err := store.ParseVersion(store.List, listed)
+	return buf, err != nil || version >= listedVersion, nil
+}
+
+// resolve returns the id of the referenced protocol if it is cached.
+// Resolving a uri or DOI requires reading every cached protocol the
+// first time it is called.
+func (gc *getCache) resolve(ctx context.Context, ref protocolRef) (int64, bool, error) {
+	if len(ref.URI) == 0 && len(ref.DOI) == 0 {
+		return ref.ID, true, nil
+	}
+	if gc.ids == nil {
+		gc.ids = map[string]int64{}
+		for _, kind := range []store.Kind{store.List, store.Detail} {
+			ids, err := gc.store.List(ctx, kind)
+			if err != nil {
+				return 0, false, err
+			}
+			for _, id := range ids {
+				buf, err := gc.store.Get(ctx, store.Key{ID: id, Kind: kind})
+				if err != nil {
+					continue
+				}
+				p, err := store.ParseProtocol(kind, buf)
+				if err != nil {
+					continue
+				}
+				gc.addIDs(p)
+			}
+		}
+	}
+	id, ok := gc.ids[ref.String()]
+	return id, ok, nil
+}
+
+func (gc *getCache) addIDs(p api.Protocol) {
+	for _, uri := range []string{p.URI, p.VersionURI} {
+		if len(uri) > 0 {
+			gc.ids[strings.ToLower(uri)] = p.ID
+		}
+	}
+	if doi, ok := normalizeDOI(p.DOI); ok {
+		gc.ids[doi] = p.ID
+	}
+}
+
+// put saves the protocol details fetched from protocols.io in the cache
+// using the same layout as protocols download.
+func (gc *getCache) put(ctx context.Context, ref protocolRef, body []byte) error {
+	p, err := store.ParseProtocol(store.Detail, body)
+	if err != nil {
+		return fmt.Errorf("%v: not saved: decode error: %v", ref, err)
+	}
+	if !ref.matches(p) {
+		return fmt.Errorf("%v: not saved: protocols.io returned a different protocol: %v (%v)", ref, p.ID, p.URI)
+	}
+	key := store.Key{ID: p.ID, Kind: store.Detail, Version: p.VersionID}
+	if err := gc.store.Put(ctx, key, body); err != nil {
+		return err
+	}
+	if gc.ids != nil {
+		gc.addIDs(p)
 	}
-	return resp.Payload, body, err
+	fmt.Fprintf(os.Stderr, "%v: saved as %v, version %v\n", ref, key.Name(), p.VersionID)
+	return nil
 }
@@ -0,0 +1,67 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseProtocolRef(t *testing.T) {
+	for i, tc := range []struct {
+		arg   string
+		ref   protocolRef
+		apiID string
+	}{
+		{"1234", protocolRef{ID: 1234}, "1234"},
+		{" 1234 ", protocolRef{ID: 1234}, "1234"},
+		{"Gel-Electrophoresis-abc123", protocolRef{URI: "gel-electrophoresis-abc123"}, "gel-electrophoresis-abc123"},
+		{"https://www.protocols.io/view/gel-electrophoresis-abc123", protocolRef{URI: "gel-electrophoresis-abc123"}, "gel-electrophoresis-abc123"},
+		{"https://www.protocols.io/view/gel-electrophoresis-abc123/abstract", protocolRef{URI: "gel-electrophoresis-abc123"}, "gel-electrophoresis-abc123"},
+		{"10.17504/protocols.io.ABC123", protocolRef{DOI: "10.17504/protocols.io.abc123"}, "dx.doi.org/10.17504/protocols.io.abc123"},
+		{"doi:10.17504/protocols.io.abc123", protocolRef{DOI: "10.17504/protocols.io.abc123"}, "dx.doi.org/10.17504/protocols.io.abc123"},
+		{"https://doi.org/10.17504/protocols.io.abc123", protocolRef{DOI: "10.17504/protocols.io.abc123"}, "dx.doi.org/10.17504/protocols.io.abc123"},
+		{"http://dx.doi.org/10.17504/protocols.io.abc123", protocolRef{DOI: "10.17504/protocols.io.abc123"}, "dx.doi.org/10.17504/protocols.io.abc123"},
+		{"dx.doi.org/10.17504/protocols.io.abc123", protocolRef{DOI: "10.17504/protocols.io.abc123"}, "dx.doi.org/10.17504/protocols.io.abc123"},
+	} {
+		ref, err := parseProtocolRef(tc.arg)
+		if err != nil {
+			t.Errorf("%v: %v: %v", i, tc.arg, err)
+			continue
+		}
+		if got, want := ref, tc.ref; got != want {
+			t.Errorf("%v: %v: got %+v, want %+v", i, tc.arg, got, want)
+		}
+		if got, want := ref.apiID(), tc.apiID; got != want {
+			t.Errorf("%v: %v: got %v, want %v", i, tc.arg, got, want)
+		}
+	}
+	for i, tc := range []string{"", "  ", "https://www.protocols.io/workspaces/x", "a/b"} {
+		if _, err := parseProtocolRef(tc); err == nil {
+			t.Errorf("%v: %q: expected an error", i, tc)
+		}
+	}
+}
+
+func TestNormalizeDOI(t *testing.T) {
+	for i, tc := range []struct {
+		input, doi string
+		ok         bool
+	}{
+		{"10.17504/protocols.io.abc", "10.17504/protocols.io.abc", true},
+		{"DOI:10.17504/Protocols.IO.abc", "10.17504/protocols.io.abc", true},
+		{"https://dx.doi.org/10.17504/protocols.io.abc", "10.17504/protocols.io.abc", true},
+		{"10.17504", "", false},
+		{"protocols.io.abc", "", false},
+		{"", "", false},
+	} {
+		doi, ok := normalizeDOI(tc.input)
+		if got, want := doi, tc.doi; got != want {
+			t.Errorf("%v: %v: got %v, want %v", i, tc.input, got, want)
+		}
+		if got, want := ok, tc.ok; got != want {
+			t.Errorf("%v: %v: got %v, want %v", i, tc.input, got, want)
+		}
+	}
+}
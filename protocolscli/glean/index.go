@@ -6,19 +6,15 @@ package glean
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"io/fs"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/cosnicolaou/glean/gleancli/config"
 	"github.com/cosnicolaou/gleansdk"
 	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/api/richtext"
+	"github.com/cosnicolaou/protocolsio/store"
 )
 
 type BulkIndexFlags struct {
@@ -118,17 +114,7 @@ func gleanDocument(p *api.Protocol) gleansdk.DocumentDefinition {
 	gd.SetTitle(p.Title)
 	gd.Summary = &gleansdk.ContentDefinition{}
 	gd.Summary.SetMimeType("text/plain")
-	var tmpDesc struct {
-		Blocks []struct {
-			Text string
-			Key  string
-		}
-	}
-	if err := json.Unmarshal([]byte(p.Description), &tmpDesc); err == nil && len(tmpDesc.Blocks) > 0 {
-		gd.Summary.SetTextContent(tmpDesc.Blocks[0].Text)
-	} else {
-		gd.Summary.SetTextContent(p.Description)
-	}
+	gd.Summary.SetTextContent(richtext.Text(p.Description))
 
 	gd.Author = &gleansdk.UserReferenceDefinition{}
 	gd.Author.SetName(p.Creator.Name)
@@ -137,21 +123,23 @@ func gleanDocument(p *api.Protocol) gleansdk.DocumentDefinition {
 	gd.Permissions = &gleansdk.DocumentPermissionsDefinition{}
 	gd.Permissions.SetAllowAnonymousAccess(true)
 	gd.CreatedAt = new(int64)
-	*gd.CreatedAt = int64(p.CreatedOn)
+	*gd.CreatedAt = p.CreatedOn.Unix()
 	return gd
 }
 
 func newDirLister(dir string) (*dirLister, error) {
-	d, err := os.Open(dir)
+	st, err := store.OpenReadOnly("", dir)
 	if err != nil {
 		return nil, err
 	}
-	return &dirLister{dir: dir, df: d}, nil
+	return &dirLister{dir: dir, store: st}, nil
 }
 
+// dirLister reads the protocols in a cache directory, via whichever
+// store backend was used to create it.
 type dirLister struct {
-	dir string
-	df  *os.File
+	dir   string
+	store store.Store
 }
 
 type dirListResult struct {
@@ -161,40 +149,44 @@ type dirListResult struct {
 }
 
 func (dl dirLister) stream(ctx context.Context, ch chan<- dirListResult) {
+	defer dl.store.Close()
 	numEntries := 50
-	for {
+	ids, err := dl.store.List(ctx, store.Detail)
+	if err != nil {
+		ch <- dirListResult{err: err}
+		return
+	}
+	for len(ids) > 0 {
 		select {
 		case <-ctx.Done():
 			ch <- dirListResult{err: ctx.Err()}
+			return
 		default:
 		}
-		de, err := dl.df.ReadDir(numEntries)
-		if err == io.EOF {
-			return
+		n := numEntries
+		if n > len(ids) {
+			n = len(ids)
 		}
-		if err != nil {
-			ch <- dirListResult{err: err}
+		lr := dl.readProtocols(ctx, ids[:n])
+		ids = ids[n:]
+		lr.lastPage = len(ids) == 0
+		ch <- lr
+		if lr.err != nil {
 			return
 		}
-		dl := dl.readFiles(de)
-		dl.lastPage = len(de) != numEntries
-		ch <- dl
 	}
 }
 
-func (dl dirLister) readFiles(entries []fs.DirEntry) dirListResult {
+func (dl dirLister) readProtocols(ctx context.Context, ids []int64) dirListResult {
 	var lr dirListResult
-	for _, f := range entries {
-		if !strings.HasSuffix(f.Name(), ".detail") {
-			continue
-		}
-		buf, err := os.ReadFile(filepath.Join(dl.dir, f.Name()))
+	for _, id := range ids {
+		buf, err := dl.store.Get(ctx, store.Key{ID: id, Kind: store.Detail})
 		if err != nil {
 			return dirListResult{err: err}
 		}
 		p, err := api.ParsePayload[api.Protocol](buf)
 		if err != nil {
-			return dirListResult{err: err}
+			return dirListResult{err: fmt.Errorf("%v: %v", store.Key{ID: id, Kind: store.Detail}.Name(), err)}
 		}
 		lr.protocols = append(lr.protocols, &p)
 	}
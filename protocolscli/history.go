@@ -0,0 +1,99 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"cloudeng.io/errors"
+	"github.com/cosnicolaou/protocolsio/store"
+)
+
+type ProtocolsHistoryFlags struct {
+	CacheFlags
+	Version int `subcmd:"version,0,'print the cached details of the specified version rather than listing the versions'"`
+}
+
+func protocolsHistoryCmd(ctx context.Context, values interface{}, args []string) error {
+	fv := values.(*ProtocolsHistoryFlags)
+	dir, err := fv.cachePath()
+	if err != nil {
+		return err
+	}
+	st, err := openStoreReadOnly(dir)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	errs := errors.M{}
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			errs.Append(fmt.Errorf("invalid protocol id: %q: %v", arg, err))
+			continue
+		}
+		if fv.Version != 0 {
+			buf, err := st.Get(ctx, store.Key{ID: id, Kind: store.Detail, Version: fv.Version})
+			if err != nil {
+				errs.Append(err)
+				continue
+			}
+			fmt.Printf("%s\n", buf)
+			continue
+		}
+		errs.Append(printHistory(ctx, st, id))
+	}
+	return errs.Err()
+}
+
+// printHistory prints the versions of the specified protocol that are
+// stored in the cache, using the list entries for protocols whose
+// details have not been downloaded.
+func printHistory(ctx context.Context, st store.Store, id int64) error {
+	kind := store.Detail
+	versions, err := st.Versions(ctx, id, kind)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		kind = store.List
+		if versions, err = st.Versions(ctx, id, kind); err != nil {
+			return err
+		}
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("%v: %w", store.Key{ID: id, Kind: store.Detail}.Name(), store.ErrNotFound)
+	}
+	for i, v := range versions {
+		key := store.Key{ID: id, Kind: kind, Version: v}
+		buf, err := st.Get(ctx, key)
+		if err != nil {
+			fmt.Printf("%v: version: %v: %v\n", id, v, err)
+			continue
+		}
+		p, err := store.ParseProtocol(kind, buf)
+		if err != nil {
+			fmt.Printf("%v: version: %v: %v: %v\n", id, v, store.ErrCorrupt, err)
+			continue
+		}
+		latest := ""
+		if i == len(versions)-1 {
+			latest = " (latest)"
+		}
+		fmt.Printf("%v: version: %v%v, published: %v, changed: %v, %v: %v\n",
+			id, v, latest, formatDate(p.PublishedOn), formatDate(p.ChangedOn), kind, p.Title)
+	}
+	return nil
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -8,6 +8,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 
 	"cloudeng.io/errors"
 	"github.com/cosnicolaou/protocolsio/api"
@@ -20,11 +22,20 @@ type ProtocolsListFlags struct {
 	Sort  string `subcmd:"sort,asc,one of asc or desc"`
 }
 
+// ProtocolsListCmdFlags are the flags for protocols list, which in
+// addition to the query flags accepts the output flags.
+type ProtocolsListCmdFlags struct {
+	ProtocolsListFlags
+	OutputFlags
+}
+
 type protocolItemProcessor interface {
 	Process(context.Context, api.ListProtocolsV3, checkpoint) error
 }
 
-type itemPrinter struct{}
+type itemPrinter struct {
+	formatter *protocolFormatter
+}
 
 func (ip *itemPrinter) Process(ctx context.Context, protocols api.ListProtocolsV3, cp checkpoint) error {
 	errs := errors.M{}
@@ -34,15 +45,28 @@ func (ip *itemPrinter) Process(ctx context.Context, protocols api.ListProtocolsV
 			errs.Append(err)
 			continue
 		}
-		fmt.Printf("%v: URI: %v, Title: %v\n", p.ID, p.URI, p.Title)
+		errs.Append(ip.formatter.write(p, item))
 	}
-	return nil
+	return errs.Err()
+}
+
+func printListItem(w io.Writer, p api.Protocol, raw []byte) error {
+	_, err := fmt.Fprintf(w, "%v: URI: %v, Title: %v\n", p.ID, p.URI, p.Title)
+	return err
 }
 
 func protocolsListCmd(ctx context.Context, values interface{}, args []string) error {
-	ck, err := newCheckpointFromFlags(values.(*ProtocolsListFlags))
+	fv := values.(*ProtocolsListCmdFlags)
+	ck, err := newCheckpointFromFlags(&fv.ProtocolsListFlags)
 	if err != nil {
 		return err
 	}
-	return getProtocols(ctx, ck, &itemPrinter{})
+	pf, err := newProtocolFormatter(os.Stdout, fv.OutputFlags, printListItem)
+	if err != nil {
+		return err
+	}
+	errs := errors.M{}
+	errs.Append(getProtocols(ctx, ck, &itemPrinter{formatter: pf}))
+	errs.Append(pf.flush())
+	return errs.Err()
 }
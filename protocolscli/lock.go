@@ -0,0 +1,152 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	lockFilename      = "lock.json"
+	lockRetryInterval = 2 * time.Second
+)
+
+// CacheLockFlags are used by all commands that lock the cache.
+type CacheLockFlags struct {
+	Wait bool `subcmd:"wait,false,'wait for any other process writing to the cache directory to finish rather than failing immediately'"`
+}
+
+// lockInfo is the content of a lock file.
+type lockInfo struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+}
+
+func (li lockInfo) String() string {
+	if li.PID == 0 {
+		return "an unknown process"
+	}
+	return fmt.Sprintf("pid %v on %v since %v", li.PID, li.Host, li.Started.Format(time.RFC3339))
+}
+
+// cacheLock is an advisory lock on a cache directory. It is represented
+// by a file, lock.json, on which the process holding the lock holds an
+// operating system lock (see lockFile) and which records the pid and
+// host of the process holding an exclusive lock for use in messages.
+// Commands that write to the cache hold an exclusive lock, those that
+// only need a consistent view of the cache, and only write files that
+// are replaced atomically, may instead hold a shared lock. The operating
+// system releases the lock when the process exits, even if it crashes or
+// is killed, so a lock can never be left behind and there is no need to
+// detect, and remove, stale locks. The lock file itself is never
+// removed since removing it would allow two processes to hold locks on
+// different files of the same name.
+type cacheLock struct {
+	filename string
+	shared   bool
+	f        *os.File
+}
+
+// lockCache acquires the exclusive lock for dir, creating dir if
+// necessary. If wait is false it fails immediately if another process
+// holds the lock, otherwise it waits for the lock to be released or for
+// the context to be canceled.
+func lockCache(ctx context.Context, dir string, wait bool) (*cacheLock, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return acquireLock(ctx, dir, wait, false)
+}
+
+// lockCacheShared acquires a shared lock for dir, which must exist, that
+// may be held by multiple processes but excludes any process holding the
+// exclusive lock. wait is interpreted as for lockCache.
+func lockCacheShared(ctx context.Context, dir string, wait bool) (*cacheLock, error) {
+	return acquireLock(ctx, dir, wait, true)
+}
+
+func acquireLock(ctx context.Context, dir string, wait, shared bool) (*cacheLock, error) {
+	host, _ := os.Hostname()
+	filename := filepath.Join(dir, lockFilename)
+	reported := false
+	for {
+		f, locked, err := lockFile(filename, shared)
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			if shared {
+				return &cacheLock{filename: filename, shared: true, f: f}, nil
+			}
+			info := lockInfo{PID: os.Getpid(), Host: host, Started: time.Now().UTC()}
+			if err := writeLockInfo(f, info); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%v: %v", filename, err)
+			}
+			return &cacheLock{filename: filename, f: f}, nil
+		}
+		// An unreadable, or empty, lock file is being written by the
+		// holder of an exclusive lock or is held by processes with
+		// shared locks.
+		holder, _ := readLockFile(filename)
+		if !wait {
+			return nil, fmt.Errorf("%v: cache directory is locked by %v: use --wait to wait for it to be released", filename, holder)
+		}
+		if !reported {
+			fmt.Printf("%v: waiting for lock held by %v\n", filename, holder)
+			reported = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// writeLockInfo replaces the contents of the locked file f with info.
+func writeLockInfo(f *os.File, info lockInfo) error {
+	buf, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(append(buf, '\n'), 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func readLockFile(filename string) (lockInfo, error) {
+	var info lockInfo
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(buf, &info)
+	return info, err
+}
+
+// release releases the lock.
+func (cl *cacheLock) release() error {
+	if cl.shared {
+		return cl.f.Close()
+	}
+	// Clear the holder's details before releasing the lock so that they
+	// are not reported for a lock that is no longer held.
+	err := cl.f.Truncate(0)
+	if cerr := cl.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheLock(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	first, err := lockCache(ctx, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := readLockFile(first.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.PID, os.Getpid(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	_, err = lockCache(ctx, dir, false)
+	if err == nil || !strings.Contains(err.Error(), "cache directory is locked by pid") {
+		t.Fatalf("missing or unexpected error: %v", err)
+	}
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := lockCache(tctx, dir, true); err != context.DeadlineExceeded {
+		t.Fatalf("missing or unexpected error: %v", err)
+	}
+	if err := first.release(); err != nil {
+		t.Fatal(err)
+	}
+	second, err := lockCache(ctx, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := second.release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSharedCacheLock(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	first, err := lockCacheShared(ctx, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := lockCacheShared(ctx, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockCache(ctx, dir, false); err == nil || !strings.Contains(err.Error(), "cache directory is locked by") {
+		t.Fatalf("missing or unexpected error: %v", err)
+	}
+	for _, l := range []*cacheLock{first, second} {
+		if err := l.release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exclusive, err := lockCache(ctx, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockCacheShared(ctx, dir, false); err == nil || !strings.Contains(err.Error(), "cache directory is locked by pid") {
+		t.Fatalf("missing or unexpected error: %v", err)
+	}
+	if err := exclusive.release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockCacheShared(ctx, filepath.Join(dir, "missing"), false); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing or unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const errorSharingViolation syscall.Errno = 32
+
+// lockFile opens, creating if necessary, filename such that no other
+// process may open it for writing until it is closed, which provides
+// an exclusive lock that windows releases when the process exits. Other
+// processes may still read the file. A shared lock opens the file for
+// reading only, which excludes an exclusive lock but not other shared
+// locks. It returns false, rather than blocking, if another process
+// holds a conflicting lock.
+func lockFile(filename string, shared bool) (*os.File, bool, error) {
+	name, err := syscall.UTF16PtrFromString(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	var access uint32 = syscall.GENERIC_READ | syscall.GENERIC_WRITE
+	if shared {
+		access = syscall.GENERIC_READ
+	}
+	h, err := syscall.CreateFile(name,
+		access,
+		syscall.FILE_SHARE_READ,
+		nil,
+		syscall.OPEN_ALWAYS,
+		syscall.FILE_ATTRIBUTE_NORMAL,
+		0)
+	if err != nil {
+		if errors.Is(err, errorSharingViolation) {
+			return nil, false, nil
+		}
+		return nil, false, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+	return os.NewFile(uintptr(h), filename), true, nil
+}
@@ -6,11 +6,14 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"strings"
 
 	"cloudeng.io/cmdutil/signals"
 	"cloudeng.io/cmdutil/subcmd"
+	"cloudeng.io/errors"
+	"github.com/cosnicolaou/protocolsio/api"
 	"github.com/cosnicolaou/protocolsio/protocolscli/glean"
 )
 
@@ -21,6 +24,7 @@ type GlobalFlags struct {
 var (
 	globalFlags  GlobalFlags
 	globalConfig *Config
+	globalClient *api.Client
 	cmdSet       *subcmd.CommandSetYAML
 )
 
@@ -44,11 +48,49 @@ commands:
         summary: list protocols
       - name: download
         summary: download protocols
+      - name: sync
+        summary: download protocols published or changed since the last sync
+      - name: retry-failed
+        summary: retry downloading the protocols recorded in the failures ledger
       - name: get
-        summary: get a specific protocol
+        summary: get specific protocols, from the cache if it contains a current copy, by id, uri, protocols.io URL or DOI
         arguments:
           - id
           - ...
+      - name: history
+        summary: list the versions of protocols stored in the cache
+        arguments:
+          - id
+          - ...
+      - name: diff
+        summary: show the differences between two cached versions of a protocol, by default the two most recent
+        arguments:
+          - id [v1] [v2]
+          - ...
+      - name: export
+        summary: export cached protocols as Markdown, standalone HTML or Bioschemas LabProtocol JSON-LD documents, one of markdown, html or jsonld followed by the protocols to export, or --all
+        arguments:
+          - format
+          - ...
+      - name: search
+        summary: search the cached protocols offline, terms may be quoted phrases and restricted to a field, eg. author:smith or title:"gel electrophoresis"
+        arguments:
+          - query
+          - ...
+      - name: cache
+        summary: manage the local cache of downloaded protocols
+        commands:
+          - name: verify
+            summary: verify, and optionally repair, the files in the cache
+  - name: auth
+    summary: manage OAuth2 credentials for accessing private protocols
+    commands:
+      - name: login
+        summary: obtain a user token via the OAuth2 authorization code flow
+        arguments:
+          - '[code]'
+      - name: logout
+        summary: remove any cached token
 ` + indent("  ", glean.SubcmdYAML)
 
 func init() {
@@ -57,14 +99,41 @@ func init() {
 	globals.MustRegisterFlagStruct(&globalFlags, nil, nil)
 
 	cmdSet.Set("protocols", "list").RunnerAndFlags(
-		protocolsListCmd, subcmd.MustRegisteredFlagSet(&ProtocolsListFlags{}))
+		protocolsListCmd, subcmd.MustRegisteredFlagSet(&ProtocolsListCmdFlags{}))
 
 	cmdSet.Set("protocols", "download").RunnerAndFlags(
 		protocolsDownloadCmd, subcmd.MustRegisteredFlagSet(&ProtocolsDownloadFlags{}))
 
+	cmdSet.Set("protocols", "sync").RunnerAndFlags(
+		protocolsSyncCmd, subcmd.MustRegisteredFlagSet(&ProtocolsSyncFlags{}))
+
+	cmdSet.Set("protocols", "retry-failed").RunnerAndFlags(
+		protocolsRetryFailedCmd, subcmd.MustRegisteredFlagSet(&ProtocolsRetryFailedFlags{}))
+
 	cmdSet.Set("protocols", "get").RunnerAndFlags(
 		protocolsGetCmd, subcmd.MustRegisteredFlagSet(&ProtocolsGetFlags{}))
 
+	cmdSet.Set("protocols", "history").RunnerAndFlags(
+		protocolsHistoryCmd, subcmd.MustRegisteredFlagSet(&ProtocolsHistoryFlags{}))
+
+	cmdSet.Set("protocols", "diff").RunnerAndFlags(
+		protocolsDiffCmd, subcmd.MustRegisteredFlagSet(&ProtocolsDiffFlags{}))
+
+	cmdSet.Set("protocols", "export").RunnerAndFlags(
+		protocolsExportCmd, subcmd.MustRegisteredFlagSet(&ProtocolsExportFlags{}))
+
+	cmdSet.Set("protocols", "search").RunnerAndFlags(
+		protocolsSearchCmd, subcmd.MustRegisteredFlagSet(&ProtocolsSearchFlags{}))
+
+	cmdSet.Set("cache", "verify").RunnerAndFlags(
+		cacheVerifyCmd, subcmd.MustRegisteredFlagSet(&CacheVerifyFlags{}))
+
+	cmdSet.Set("auth", "login").RunnerAndFlags(
+		authLoginCmd, subcmd.MustRegisteredFlagSet(&AuthLoginFlags{}))
+
+	cmdSet.Set("auth", "logout").RunnerAndFlags(
+		authLogoutCmd, subcmd.MustRegisteredFlagSet(&AuthLogoutFlags{}))
+
 	glean.ConfigureCmdSet(cmdSet)
 	cmdSet.WithGlobalFlags(globals)
 	cmdSet.WithMain(mainWrapper)
@@ -76,7 +145,36 @@ func mainWrapper(ctx context.Context, cmdRunner func(ctx context.Context) error)
 		return err
 	}
 	globalConfig = cfg
-	return cmdRunner(ctx)
+	globalClient = cfg.NewClient()
+	return withRemediationHint(cmdRunner(ctx))
+}
+
+// isError is like errors.Is but avoids calling errors.M.Unwrap, which
+// removes the error that it returns from the errors.M.
+func isError(err, target error) bool {
+	if m, ok := err.(*errors.M); ok {
+		return m.Is(target)
+	}
+	return errors.Is(err, target)
+}
+
+// withRemediationHint annotates authentication and authorization
+// errors with suggestions for how to correct them.
+func withRemediationHint(err error) error {
+	var hint string
+	switch {
+	case err == nil:
+		return nil
+	case isError(err, api.ErrNoCredentials):
+		hint = fmt.Sprintf("set public_token, or public_clientid and public_secret, in %v or run 'auth login'", globalFlags.Config)
+	case isError(err, api.ErrUnauthorized):
+		hint = fmt.Sprintf("the configured credentials were rejected, check that the token in %v is current or rerun 'auth login'", globalFlags.Config)
+	case isError(err, api.ErrForbidden):
+		hint = "the configured credentials do not grant access to the requested protocols, the user_private and shared_with_user filters require a user token obtained via 'auth login'"
+	default:
+		return err
+	}
+	return fmt.Errorf("%w\nhint: %v", err, hint)
 }
 
 func main() {
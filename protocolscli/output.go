@@ -0,0 +1,404 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"cloudeng.io/cmdutil/flags"
+	"github.com/cosnicolaou/protocolsio/api"
+	"gopkg.in/yaml.v3"
+)
+
+// OutputFlags control how protocols are displayed by the list and get
+// commands.
+type OutputFlags struct {
+	Format   string `subcmd:"format,text,'output format, one of: text, json, jsonl, yaml, csv, table or template'"`
+	Fields   string `subcmd:"fields,,'comma separated list of the protocol fields to display, named by their json names, with nested fields separated by dots, eg. id,title,authors.name,stats.number_of_views'"`
+	Template string `subcmd:"template,,'go text/template, or @filename containing one, that is executed for each protocol (an api.Protocol) when --format=template'"`
+}
+
+// OutputFormats returns the supported values for --format.
+func OutputFormats() []string {
+	return []string{"text", "json", "jsonl", "yaml", "csv", "table", "template"}
+}
+
+// defaultFields are displayed by the csv and table formats when --fields
+// is not specified.
+var defaultFields = []string{"id", "version_id", "uri", "title"}
+
+// textFormatter is used to display a protocol, and the raw JSON from
+// which it was decoded, when --format=text.
+type textFormatter func(w io.Writer, p api.Protocol, raw []byte) error
+
+// protocolFormatter displays a stream of protocols in the format
+// specified by OutputFlags. Formats that require a header or footer,
+// such as json or table, are only complete once flush has been called.
+type protocolFormatter struct {
+	out    io.Writer
+	format string
+	fields []fieldPath
+	text   textFormatter
+	tmpl   *template.Template
+	n      int
+	csv    *csv.Writer
+	table  *tabwriter.Writer
+}
+
+func newProtocolFormatter(out io.Writer, fv OutputFlags, text textFormatter) (*protocolFormatter, error) {
+	if err := flags.OneOf(fv.Format).Validate("text", OutputFormats()...); err != nil {
+		return nil, err
+	}
+	pf := &protocolFormatter{out: out, format: fv.Format, text: text}
+	var err error
+	if len(fv.Fields) > 0 {
+		if pf.fields, err = parseFieldPaths(strings.Split(fv.Fields, ",")); err != nil {
+			return nil, err
+		}
+	}
+	switch pf.format {
+	case "text":
+		if len(pf.fields) > 0 {
+			return nil, fmt.Errorf("--fields is not supported by --format=text")
+		}
+	case "template":
+		if len(fv.Template) == 0 {
+			return nil, fmt.Errorf("--format=template requires a template to be specified via --template")
+		}
+		if pf.tmpl, err = parseTemplate(fv.Template); err != nil {
+			return nil, err
+		}
+	case "csv", "table":
+		if len(pf.fields) == 0 {
+			pf.fields, _ = parseFieldPaths(defaultFields)
+		}
+	}
+	return pf, nil
+}
+
+// parseTemplate parses the supplied template, reading it from a file if
+// it is of the form @filename.
+func parseTemplate(text string) (*template.Template, error) {
+	name := "--template"
+	if strings.HasPrefix(text, "@") {
+		name = text[1:]
+		buf, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		text = string(buf)
+	}
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return template.New(name).Parse(text)
+}
+
+// write displays p, which was decoded from raw.
+func (pf *protocolFormatter) write(p api.Protocol, raw []byte) error {
+	defer func() { pf.n++ }()
+	switch pf.format {
+	case "text":
+		return pf.text(pf.out, p, raw)
+	case "template":
+		return pf.tmpl.Execute(pf.out, p)
+	case "json":
+		buf, err := json.MarshalIndent(pf.record(p), "  ", "  ")
+		if err != nil {
+			return err
+		}
+		sep := ",\n"
+		if pf.n == 0 {
+			sep = "[\n"
+		}
+		_, err = fmt.Fprintf(pf.out, "%s  %s", sep, buf)
+		return err
+	case "jsonl":
+		buf, err := json.Marshal(pf.record(p))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(pf.out, "%s\n", buf)
+		return err
+	case "yaml":
+		v, err := yamlValue(pf.record(p))
+		if err != nil {
+			return err
+		}
+		// Each protocol is written as a single element sequence so that
+		// the output as a whole is a valid yaml sequence.
+		buf, err := yaml.Marshal([]any{v})
+		if err != nil {
+			return err
+		}
+		_, err = pf.out.Write(buf)
+		return err
+	case "csv":
+		if pf.csv == nil {
+			pf.csv = csv.NewWriter(pf.out)
+			if err := pf.csv.Write(pf.header()); err != nil {
+				return err
+			}
+		}
+		return pf.csv.Write(pf.row(p))
+	case "table":
+		if pf.table == nil {
+			pf.table = tabwriter.NewWriter(pf.out, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(pf.table, strings.Join(pf.header(), "\t"))
+		}
+		_, err := fmt.Fprintln(pf.table, strings.Join(pf.row(p), "\t"))
+		return err
+	}
+	return nil
+}
+
+// flush completes the output.
+func (pf *protocolFormatter) flush() error {
+	switch pf.format {
+	case "json":
+		if pf.n == 0 {
+			_, err := fmt.Fprintln(pf.out, "[]")
+			return err
+		}
+		_, err := fmt.Fprintln(pf.out, "\n]")
+		return err
+	case "csv":
+		if pf.csv != nil {
+			pf.csv.Flush()
+			return pf.csv.Error()
+		}
+	case "table":
+		if pf.table != nil {
+			return pf.table.Flush()
+		}
+	}
+	return nil
+}
+
+// record returns the value to be encoded for p, either p itself or
+// the selected fields.
+func (pf *protocolFormatter) record(p api.Protocol) any {
+	if len(pf.fields) == 0 {
+		return p
+	}
+	r := make(record, len(pf.fields))
+	for i, f := range pf.fields {
+		r[i] = recordField{name: f.name, value: f.value(p)}
+	}
+	return r
+}
+
+func (pf *protocolFormatter) header() []string {
+	h := make([]string, len(pf.fields))
+	for i, f := range pf.fields {
+		h[i] = f.name
+	}
+	return h
+}
+
+func (pf *protocolFormatter) row(p api.Protocol) []string {
+	row := make([]string, len(pf.fields))
+	for i, f := range pf.fields {
+		row[i] = formatValue(f.value(p))
+		if pf.format == "table" {
+			// Tabs and newlines would break the table's alignment.
+			row[i] = strings.Join(strings.Fields(row[i]), " ")
+		}
+	}
+	return row
+}
+
+type recordField struct {
+	name  string
+	value any
+}
+
+// record represents the selected fields of a protocol and is encoded
+// as an object whose fields are in the order in which they were selected.
+type record []recordField
+
+// MarshalJSON implements json.Marshaler.
+func (r record) MarshalJSON() ([]byte, error) {
+	var out strings.Builder
+	out.WriteByte('{')
+	for i, f := range r {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		name, _ := json.Marshal(f.name)
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(name)
+		out.WriteByte(':')
+		out.Write(value)
+	}
+	out.WriteByte('}')
+	return []byte(out.String()), nil
+}
+
+// yamlValue returns a yaml representation of v that uses the same field
+// names as its json encoding.
+func yamlValue(v any) (*yaml.Node, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// yaml is a superset of json and unmarshaling into a node, unlike
+	// a map, preserves the order of the fields.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, err
+	}
+	node := doc.Content[0]
+	resetStyle(node)
+	return node, nil
+}
+
+// resetStyle removes the flow and quoting styles inherited from json,
+// the encoder quotes strings where required.
+func resetStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		resetStyle(c)
+	}
+}
+
+// formatValue returns the value of a field as a single string for the
+// csv and table formats. Lists are comma separated and objects are
+// json encoded.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	case []any:
+		s := make([]string, len(v))
+		for i, e := range v {
+			s[i] = formatValue(e)
+		}
+		return strings.Join(s, ", ")
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v)
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(buf)
+}
+
+var (
+	protocolType = reflect.TypeOf(api.Protocol{})
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// fieldPath is a parsed --fields selector, the index of the struct
+// field for each component of its dotted name.
+type fieldPath struct {
+	name    string
+	indices [][]int
+}
+
+// parseFieldPaths parses and validates the supplied field names against
+// the api.Protocol type.
+func parseFieldPaths(names []string) ([]fieldPath, error) {
+	paths := make([]fieldPath, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		fp := fieldPath{name: name}
+		t := protocolType
+		for _, component := range strings.Split(name, ".") {
+			t = elemType(t)
+			if t.Kind() != reflect.Struct || t == timeType {
+				return nil, fmt.Errorf("invalid field: %q: %q is not an object", name, strings.TrimSuffix(name, "."+component))
+			}
+			f, ok := fieldByJSONName(t, component)
+			if !ok {
+				return nil, fmt.Errorf("unknown field: %q: no such field %q in %v", name, component, t.Name())
+			}
+			fp.indices = append(fp.indices, f.Index)
+			t = f.Type
+		}
+		paths = append(paths, fp)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no fields specified")
+	}
+	return paths, nil
+}
+
+// elemType returns the type of the values referred to by pointers and
+// slices of t.
+func elemType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t
+}
+
+func fieldByJSONName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// value returns the value of the field in p. Fields within lists are
+// returned as a list of the values of that field in each element.
+func (fp fieldPath) value(p api.Protocol) any {
+	return fieldValue(reflect.ValueOf(p), fp.indices)
+}
+
+func fieldValue(v reflect.Value, indices [][]int) any {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if len(indices) == 0 {
+		return v.Interface()
+	}
+	if v.Kind() == reflect.Slice {
+		values := make([]any, v.Len())
+		for i := range values {
+			values[i] = fieldValue(v.Index(i), indices)
+		}
+		return values
+	}
+	return fieldValue(v.FieldByIndex(indices[0]), indices[1:])
+}
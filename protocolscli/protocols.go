@@ -6,9 +6,9 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"net/url"
 	"strconv"
-	"strings"
 
 	"cloudeng.io/cmdutil/flags"
 	"github.com/cosnicolaou/protocolsio/api"
@@ -67,67 +67,24 @@ func getProtocols(ctx context.Context, checkpoint checkpoint, proccessor protoco
 }
 
 func getProtocolsCall(ctx context.Context, checkpoint checkpoint, ch chan<- downloadedItems) {
-	lastPage := strconv.Itoa(checkpoint.Pages.To)
-	if checkpoint.Pages.To == 0 && !checkpoint.Pages.ExtendsToEnd {
-		lastPage = strconv.Itoa(checkpoint.Pages.From)
-	}
-	ctx = globalConfig.WithAuth(ctx)
-	u := globalConfig.Endpoints.ListProtocolsV3
-	u = strings.TrimSuffix(u, "/?")
-	u += "?"
 	v := url.Values{}
 	checkpoint.initHeaders(&v)
-	nItems := 0
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
+	var extras json.RawMessage
+	pg := api.Paginate(func(ctx context.Context, pageID int) ([]json.RawMessage, api.Pagination, error) {
+		v.Set("page_id", strconv.Itoa(pageID))
+		resp, _, err := globalClient.ListProtocols(ctx, v)
+		extras = resp.Extras
+		return resp.Items, resp.Pagination, err
+	}, checkpoint.paginateOptions())
+	for pg.NextPage(ctx) {
+		checkpoint.update(pg)
 		var result downloadedItems
-
-		resp, _, err := api.Get[api.ListProtocolsV3](ctx, u+v.Encode())
-		if err != nil {
-			result.err = err
-			ch <- result
-			return
-		}
-
-		done, nextPage, err := checkpoint.update(resp.Pagination)
-		if err != nil {
-			ch <- result
-			return
-		}
 		result.checkpoint = checkpoint
-		result.protocols.Extras = resp.Extras
-
-		if checkpoint.Total > 0 {
-			nItems += len(resp.Items)
-			if nItems <= checkpoint.Total {
-				result.protocols.Items = resp.Items
-				ch <- result
-			} else {
-				rem := resp.Items[:checkpoint.Total-(nItems-len(resp.Items))]
-				result.protocols.Items = rem
-				ch <- result
-			}
-			if nItems >= checkpoint.Total {
-				break
-			}
-		} else {
-			result.protocols.Items = resp.Items
-			ch <- result
-		}
-		if done {
-			break
-		}
-		if checkpoint.Total == 0 {
-			if !checkpoint.Pages.ExtendsToEnd && v.Get("page_id") == lastPage {
-				break
-			}
-		}
-		v.Set("page_id", strconv.Itoa(nextPage))
+		result.protocols.Extras = extras
+		result.protocols.Items = pg.Page()
+		ch <- result
+	}
+	if err := pg.Err(); err != nil {
+		ch <- downloadedItems{err: err}
 	}
-	return
 }
@@ -0,0 +1,76 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/cosnicolaou/protocolsio/search"
+)
+
+// searchIndexFilename is the name of the search index in the cache.
+const searchIndexFilename = "search.idx"
+
+type ProtocolsSearchFlags struct {
+	CacheFlags
+	CacheLockFlags
+	Limit   int  `subcmd:"limit,20,maximum number of results to display"`
+	Reindex bool `subcmd:"reindex,false,'rebuild the search index from scratch rather than updating it'"`
+}
+
+func protocolsSearchCmd(ctx context.Context, values interface{}, args []string) error {
+	fv := values.(*ProtocolsSearchFlags)
+	query, err := search.ParseQuery(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	dir, err := fv.cachePath()
+	if err != nil {
+		return err
+	}
+	// A shared lock ensures that the index is not updated whilst the
+	// cache is being written to by download, sync etc, whilst allowing
+	// concurrent searches, each of which saves the index atomically.
+	lock, err := lockCacheShared(ctx, dir, fv.Wait)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	st, err := openStoreReadOnly(dir)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	filename := filepath.Join(dir, searchIndexFilename)
+	var idx *search.Index
+	if fv.Reindex {
+		idx = search.New(filename)
+	} else if idx, err = search.Open(filename); err != nil {
+		return err
+	}
+	stats, err := idx.Update(ctx, st, func(id int64, err error) {
+		fmt.Printf("%v: not indexed: %v\n", id, err)
+	})
+	if err != nil {
+		return err
+	}
+	if stats.Added+stats.Updated+stats.Removed > 0 {
+		fmt.Printf("indexed: added: %v, updated: %v, removed: %v, total: %v\n", stats.Added, stats.Updated, stats.Removed, idx.Len())
+	}
+	if err := idx.Save(); err != nil {
+		return err
+	}
+	results := idx.Search(query, fv.Limit)
+	for i, r := range results {
+		fmt.Printf("%3d. %v: %v (version: %v, score: %.2f) URI: %v\n", i+1, r.ID, r.Title, r.Version, r.Score, r.URI)
+	}
+	if len(results) == 0 {
+		fmt.Printf("no protocols match: %v\n", query)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"cloudeng.io/cmdutil/flags"
+	"cloudeng.io/errors"
+	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/store"
+)
+
+type ProtocolsSyncFlags struct {
+	CacheLockFlags
+	PageSize    int    `subcmd:"size,50,number of items in each page"`
+	Filter      string `subcmd:"filter,public,'one of public, user_public, user_private or shared_with_user, see protocols list'"`
+	CacheDir    string `subcmd:"cachepath,,'location of cache of download protocol objects that overides that specified in the global yaml config'"`
+	Concurrency int    `subcmd:"concurrency,4,'number of protocol details to fetch concurrently, all fetches share the rate limit set in the config file'"`
+	Full        bool   `subcmd:"full,false,'ignore the recorded high-water mark and examine every protocol'"`
+}
+
+// syncState records the progress of previous syncs. It is stored in the
+// cache directory, one file per filter.
+type syncState struct {
+	Filter        string    `json:"filter"`
+	HighWaterMark time.Time `json:"high_water_mark"` // Most recent publish/change date seen.
+	LastSync      time.Time `json:"last_sync"`
+}
+
+func syncStateFile(dir, filter string) string {
+	return filepath.Join(dir, "sync_"+filter+".json")
+}
+
+func readSyncState(dir, filter string) (syncState, error) {
+	state := syncState{Filter: filter}
+	buf, err := os.ReadFile(syncStateFile(dir, filter))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return state, nil
+		}
+		return state, err
+	}
+	if err := json.Unmarshal(buf, &state); err != nil {
+		return state, fmt.Errorf("failed to decode sync state: %v: %v", syncStateFile(dir, filter), err)
+	}
+	return state, nil
+}
+
+func writeSyncState(dir string, state syncState) error {
+	buf, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(syncStateFile(dir, state.Filter), buf, 0600)
+}
+
+// syncStats records the outcome of a sync.
+type syncStats struct {
+	added, updated, unchanged int
+	mark                      time.Time // most recent modification time of the protocols stored.
+	failed                    time.Time // least recent modification time of the protocols that failed.
+}
+
+func protocolsSyncCmd(ctx context.Context, values interface{}, args []string) error {
+	fv := values.(*ProtocolsSyncFlags)
+	if err := flags.OneOf(fv.Filter).Validate("public", ProtocolListFilters()...); err != nil {
+		return err
+	}
+	dir, err := cachePath(fv.CacheDir)
+	if err != nil {
+		return err
+	}
+	lock, err := lockCache(ctx, dir, fv.Wait)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	saver, err := newItemSaver(dir, fv.Concurrency)
+	if err != nil {
+		return err
+	}
+	defer saver.close()
+	state, err := readSyncState(dir, fv.Filter)
+	if err != nil {
+		return err
+	}
+	mark := state.HighWaterMark
+	if fv.Full {
+		mark = time.Time{}
+	}
+	cp := checkpoint{
+		Pages:      flags.IntRangeSpec{From: 1, ExtendsToEnd: true},
+		PageSize:   fv.PageSize,
+		Filter:     fv.Filter,
+		FieldOrder: "date",
+		Order:      "desc",
+	}
+	stats, err := syncProtocols(ctx, cp, mark, saver)
+	fmt.Printf("added: %v, updated: %v, unchanged: %v\n", stats.added, stats.updated, stats.unchanged)
+	if err != nil {
+		// Leave the high-water mark unchanged so that the failed
+		// protocols are examined again by the next sync.
+		return err
+	}
+	if stats.mark.After(state.HighWaterMark) {
+		state.HighWaterMark = stats.mark
+	}
+	if !stats.failed.IsZero() && !state.HighWaterMark.Before(stats.failed) {
+		// Keep the high-water mark below any protocols that failed to
+		// download so that they are examined again by the next sync.
+		state.HighWaterMark = stats.failed.Add(-time.Second)
+	}
+	state.LastSync = time.Now().UTC()
+	fmt.Printf("high-water mark: %v\n", state.HighWaterMark.Format(time.RFC3339))
+	return writeSyncState(dir, state)
+}
+
+// syncProtocols pages through the protocols, most recently published
+// first, saving any that are new or have changed. It stops at the end of
+// the first page that contains a protocol that has already been
+// downloaded, is unchanged and was last modified at or before mark.
+func syncProtocols(ctx context.Context, cp checkpoint, mark time.Time, saver *itemSaver) (syncStats, error) {
+	var stats syncStats
+	v := url.Values{}
+	cp.initHeaders(&v)
+	var extras json.RawMessage
+	pg := api.Paginate(func(ctx context.Context, pageID int) ([]json.RawMessage, api.Pagination, error) {
+		v.Set("page_id", strconv.Itoa(pageID))
+		resp, _, err := globalClient.ListProtocols(ctx, v)
+		extras = resp.Extras
+		return resp.Items, resp.Pagination, err
+	}, cp.paginateOptions())
+	for pg.NextPage(ctx) {
+		caughtUp := false
+		modified := map[int64]time.Time{}
+		for _, item := range pg.Page() {
+			var p api.Protocol
+			if err := json.Unmarshal(item, &p); err != nil {
+				return stats, err
+			}
+			modified[p.ID] = p.LastModified()
+			stored, exists, err := saver.storedDetail(ctx, p.ID)
+			if err != nil {
+				return stats, err
+			}
+			switch {
+			case !exists:
+				stats.added++
+			case !stored.current(p.VersionID, p.ChangedOn):
+				stats.updated++
+			default:
+				stats.unchanged++
+				if !mark.IsZero() && !modified[p.ID].After(mark) {
+					caughtUp = true
+				}
+			}
+		}
+		cp.update(pg)
+		page := api.ListProtocolsV3{Extras: extras, Items: pg.Page()}
+		if err := saver.save(ctx, page, &cp); err != nil {
+			return stats, err
+		}
+		// Only advance the high-water mark over protocols that were
+		// stored successfully.
+		for id, t := range modified {
+			switch {
+			case saver.failures.contains(id):
+				if stats.failed.IsZero() || t.Before(stats.failed) {
+					stats.failed = t
+				}
+			case t.After(stats.mark):
+				stats.mark = t
+			}
+		}
+		if caughtUp {
+			return stats, nil
+		}
+	}
+	return stats, pg.Err()
+}
@@ -0,0 +1,262 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/store"
+)
+
+type CacheVerifyFlags struct {
+	CacheLockFlags
+	CacheFlags
+	Repair      bool `subcmd:"repair,false,'refetch the protocols whose cached files are corrupt, missing or out of date'"`
+	Concurrency int  `subcmd:"concurrency,4,'number of protocol details to fetch concurrently when repairing the cache'"`
+}
+
+// cacheProblem represents a single problem found in the cache.
+type cacheProblem struct {
+	file   string
+	kind   string
+	detail string
+}
+
+// Kinds of cache problem.
+const (
+	problemCorrupt         = "corrupt"
+	problemStatus          = "error-status"
+	problemMissingDetail   = "missing-detail"
+	problemMissingList     = "missing-list"
+	problemVersionMismatch = "version-mismatch"
+)
+
+// cachedProtocol records what was found in the cache for a protocol.
+type cachedProtocol struct {
+	list, detail               bool
+	listVersion, detailVersion int
+	broken                     bool // the .detail file needs to be refetched.
+}
+
+type cacheVerifier struct {
+	dir       string
+	store     store.Store
+	protocols map[int64]*cachedProtocol
+	problems  []cacheProblem
+}
+
+func (cv *cacheVerifier) report(file, kind, format string, args ...any) {
+	cv.problems = append(cv.problems, cacheProblem{
+		file:   file,
+		kind:   kind,
+		detail: fmt.Sprintf(format, args...),
+	})
+}
+
+func (cv *cacheVerifier) protocol(id int64) *cachedProtocol {
+	cp, ok := cv.protocols[id]
+	if !ok {
+		cp = &cachedProtocol{}
+		cv.protocols[id] = cp
+	}
+	return cp
+}
+
+// read reads the specified object, reporting any errors.
+func (cv *cacheVerifier) read(ctx context.Context, key store.Key) ([]byte, bool) {
+	buf, err := cv.store.Get(ctx, key)
+	if err != nil {
+		cv.report(key.Name(), problemCorrupt, "%v", err)
+		return nil, false
+	}
+	return buf, true
+}
+
+func (cv *cacheVerifier) verifyList(ctx context.Context, id int64) {
+	cp := cv.protocol(id)
+	cp.list = true
+	key := store.Key{ID: id, Kind: store.List}
+	buf, ok := cv.read(ctx, key)
+	if !ok {
+		return
+	}
+	version, err := store.ParseVersion(store.List, buf)
+	if err != nil {
+		cv.report(key.Name(), problemCorrupt, "%v", err)
+		return
+	}
+	cp.listVersion = version
+}
+
+func (cv *cacheVerifier) verifyDetail(ctx context.Context, id int64) {
+	cp := cv.protocol(id)
+	cp.detail = true
+	key := store.Key{ID: id, Kind: store.Detail}
+	buf, ok := cv.read(ctx, key)
+	if !ok {
+		cp.broken = true
+		return
+	}
+	var payload api.Payload
+	if err := json.Unmarshal(buf, &payload); err != nil {
+		cv.report(key.Name(), problemCorrupt, "%v", err)
+		cp.broken = true
+		return
+	}
+	if payload.StatusCode != 0 {
+		cv.report(key.Name(), problemStatus, "status_code: %v: %v", payload.StatusCode, payload.ErrorMessage)
+		cp.broken = true
+		return
+	}
+	version, err := store.ParseVersion(store.Detail, buf)
+	if err != nil {
+		cv.report(key.Name(), problemCorrupt, "%v", err)
+		cp.broken = true
+		return
+	}
+	cp.detailVersion = version
+}
+
+func (cv *cacheVerifier) verifyCheckpoint(name string) {
+	if _, err := readCheckpoint(filepath.Join(cv.dir, name)); err != nil {
+		cv.report(name, problemCorrupt, "%v", err)
+	}
+}
+
+// crossCheck compares the .list and .detail files for each protocol.
+func (cv *cacheVerifier) crossCheck() {
+	for id, cp := range cv.protocols {
+		list, detail := store.Key{ID: id, Kind: store.List}.Name(), store.Key{ID: id, Kind: store.Detail}.Name()
+		switch {
+		case cp.list && !cp.detail:
+			cv.report(list, problemMissingDetail, "no corresponding .detail file")
+			cp.broken = true
+		case cp.detail && !cp.list:
+			cv.report(detail, problemMissingList, "no corresponding .list file")
+		case cp.list && !cp.broken && cp.detailVersion < cp.listVersion:
+			cv.report(detail, problemVersionMismatch, "detail version %v < list version %v", cp.detailVersion, cp.listVersion)
+			cp.broken = true
+		}
+	}
+}
+
+func (cv *cacheVerifier) scan(ctx context.Context) error {
+	for _, kind := range store.Kinds() {
+		ids, err := cv.store.List(ctx, kind)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if kind == store.List {
+				cv.verifyList(ctx, id)
+			} else {
+				cv.verifyDetail(ctx, id)
+			}
+		}
+	}
+	entries, err := os.ReadDir(cv.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			continue
+		}
+		if ok, _ := filepath.Match(legacyCheckpointPattern, name); ok || name == checkpointFilename {
+			cv.verifyCheckpoint(name)
+		}
+	}
+	cv.crossCheck()
+	sort.Slice(cv.problems, func(i, j int) bool {
+		return cv.problems[i].file < cv.problems[j].file
+	})
+	return nil
+}
+
+// repairs returns the protocols whose details need to be refetched.
+func (cv *cacheVerifier) repairs() []detailRequest {
+	var reqs []detailRequest
+	for id, cp := range cv.protocols {
+		if cp.broken {
+			reqs = append(reqs, detailRequest{
+				id:      id,
+				version: cp.listVersion,
+				file:    store.Key{ID: id, Kind: store.Detail}.Name(),
+				force:   true,
+			})
+		}
+	}
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].id < reqs[j].id })
+	return reqs
+}
+
+func cacheVerifyCmd(ctx context.Context, values interface{}, args []string) error {
+	fv := values.(*CacheVerifyFlags)
+	dir, err := fv.cachePath()
+	if err != nil {
+		return err
+	}
+	var saver *itemSaver
+	var st store.Store
+	if fv.Repair {
+		lock, err := lockCache(ctx, dir, fv.Wait)
+		if err != nil {
+			return err
+		}
+		defer lock.release()
+		if saver, err = newItemSaver(dir, fv.Concurrency); err != nil {
+			return err
+		}
+		defer saver.close()
+		st = saver.store
+	} else {
+		if st, err = openStoreReadOnly(dir); err != nil {
+			return err
+		}
+		defer st.Close()
+	}
+	cv := &cacheVerifier{
+		dir:       dir,
+		store:     st,
+		protocols: map[int64]*cachedProtocol{},
+	}
+	if err := cv.scan(ctx); err != nil {
+		return err
+	}
+	counts := map[string]int{}
+	for _, p := range cv.problems {
+		fmt.Printf("%v: %v: %v\n", p.file, p.kind, p.detail)
+		counts[p.kind]++
+	}
+	fmt.Printf("protocols: %v, problems: %v", len(cv.protocols), len(cv.problems))
+	for _, k := range []string{problemCorrupt, problemStatus, problemMissingDetail, problemMissingList, problemVersionMismatch} {
+		if counts[k] > 0 {
+			fmt.Printf(", %v: %v", k, counts[k])
+		}
+	}
+	fmt.Println()
+	if len(cv.problems) == 0 {
+		return nil
+	}
+	reqs := cv.repairs()
+	if !fv.Repair || len(reqs) == 0 {
+		// Problems with .list files can only be fixed by listing the
+		// protocols again.
+		hint := "use 'protocols download' to refresh the .list files"
+		if len(reqs) > 0 {
+			hint = fmt.Sprintf("use --repair to refetch %v protocols", len(reqs))
+		}
+		return fmt.Errorf("%v: found %v problems: %v", dir, len(cv.problems), hint)
+	}
+	fmt.Printf("refetching %v protocols\n", len(reqs))
+	return saver.fetchDetails(ctx, reqs)
+}
@@ -0,0 +1,109 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package search
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/cosnicolaou/protocolsio/api"
+	"github.com/cosnicolaou/protocolsio/api/richtext"
+)
+
+// Field represents a searchable field of a protocol.
+type Field uint8
+
+// The searchable fields.
+const (
+	Title Field = iota
+	Description
+	Steps
+	Materials
+	Authors
+	Keywords
+	numFields
+)
+
+var fieldNames = [numFields]string{"title", "description", "steps", "materials", "authors", "keywords"}
+
+// fieldWeights are used to weight the score of matches in each field.
+var fieldWeights = [numFields]float64{3, 1, 1, 1, 2, 2}
+
+// fieldAliases maps the names that may be used in field filters to
+// fields.
+var fieldAliases = map[string]Field{
+	"title":       Title,
+	"description": Description,
+	"desc":        Description,
+	"step":        Steps,
+	"steps":       Steps,
+	"material":    Materials,
+	"materials":   Materials,
+	"author":      Authors,
+	"authors":     Authors,
+	"keyword":     Keywords,
+	"keywords":    Keywords,
+}
+
+func (f Field) String() string {
+	if f < numFields {
+		return fieldNames[f]
+	}
+	return fmt.Sprintf("field(%d)", f)
+}
+
+// Document represents the searchable text of a protocol.
+type Document struct {
+	ID      int64
+	Version int
+	// Stamp identifies the content the document was created from, see
+	// store.Store.Stamp, and is used to determine when it must be
+	// re-indexed.
+	Stamp  string
+	URI    string
+	Title  string
+	Fields [numFields]string
+}
+
+// DocumentFor returns the Document for a protocol, rendering its rich
+// text fields as plain text.
+func DocumentFor(p api.Protocol) Document {
+	doc := Document{ID: p.ID, Version: p.VersionID, URI: p.URI, Title: p.Title}
+	doc.Fields[Title] = p.Title
+	doc.Fields[Description] = strings.Join([]string{
+		richtext.Text(p.Description),
+		richtext.Text(p.Guidelines),
+		richtext.Text(p.BeforeStart),
+		richtext.Text(p.Warning),
+	}, "\n")
+	var steps, materials, authors []string
+	for _, s := range p.Steps {
+		steps = append(steps, s.Section, richtext.Text(s.Step))
+	}
+	doc.Fields[Steps] = strings.Join(steps, "\n")
+	materials = append(materials, richtext.Text(p.MaterialsText))
+	for _, m := range p.Materials {
+		materials = append(materials, m.Name)
+		if m.Vendor != nil {
+			materials = append(materials, m.Vendor.Name)
+		}
+	}
+	doc.Fields[Materials] = strings.Join(materials, "\n")
+	for _, a := range append([]api.Author{p.Creator}, p.Authors...) {
+		authors = append(authors, a.Name, a.Username)
+	}
+	doc.Fields[Authors] = strings.Join(authors, "\n")
+	doc.Fields[Keywords] = strings.Join(p.KeywordList(), "\n")
+	return doc
+}
+
+// tokenize splits text into lower case terms consisting of letters and
+// digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
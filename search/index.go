@@ -0,0 +1,242 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package search provides an inverted index, with term positions, over
+// protocols that supports phrase queries, field filters and results
+// ranked using BM25. The index is stored in a single file and may be
+// updated incrementally as protocols are added, changed or removed.
+package search
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/cosnicolaou/protocolsio/store"
+)
+
+// formatVersion is incremented whenever the format of the index file,
+// or the way in which documents are indexed, changes.
+const formatVersion = 3
+
+// docInfo records the information about an indexed document required
+// for ranking, displaying results and removing the document.
+type docInfo struct {
+	Version int
+	Stamp   string
+	URI     string
+	Title   string
+	Lengths [numFields]int32
+	Terms   []string // distinct terms in the document.
+}
+
+// posting records the positions of a term in a field of a document. The
+// postings for each term are sorted by document ID so that those for a
+// given document can be found using a binary search.
+type posting struct {
+	Doc       int64
+	Field     Field
+	Positions []byte // delta and varint encoded.
+}
+
+func encodePositions(positions []int32) []byte {
+	buf := make([]byte, 0, len(positions))
+	prev := int32(0)
+	for _, p := range positions {
+		buf = binary.AppendUvarint(buf, uint64(p-prev))
+		prev = p
+	}
+	return buf
+}
+
+func decodePositions(buf []byte) []int32 {
+	var positions []int32
+	prev := int32(0)
+	for len(buf) > 0 {
+		d, n := binary.Uvarint(buf)
+		if n <= 0 {
+			break
+		}
+		prev += int32(d)
+		positions = append(positions, prev)
+		buf = buf[n:]
+	}
+	return positions
+}
+
+// Index is an inverted index over protocols.
+type Index struct {
+	FormatVersion int
+	Docs          map[int64]*docInfo
+	Postings      map[string][]posting
+	TotalLengths  [numFields]int64
+
+	filename string
+	changed  bool
+}
+
+// New returns a new, empty, index that will be saved to filename.
+func New(filename string) *Index {
+	return &Index{
+		FormatVersion: formatVersion,
+		Docs:          map[int64]*docInfo{},
+		Postings:      map[string][]posting{},
+		filename:      filename,
+	}
+}
+
+// Open reads the index stored in filename. A new, empty, index is
+// returned if the file does not exist or was written using a different
+// format.
+func Open(filename string) (*Index, error) {
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return New(filename), nil
+		}
+		return nil, err
+	}
+	idx := &Index{}
+	if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(idx); err != nil {
+		return nil, fmt.Errorf("%v: failed to decode index: %v", filename, err)
+	}
+	if idx.FormatVersion != formatVersion {
+		return New(filename), nil
+	}
+	idx.filename = filename
+	return idx, nil
+}
+
+// Save writes the index to its file if it has changed. The file is
+// replaced atomically, so concurrent Saves, or an Open concurrent with
+// a Save, will never see a partially written index; the last Save wins.
+func (idx *Index) Save() error {
+	if !idx.changed {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(idx); err != nil {
+		return err
+	}
+	if err := store.WriteFileAtomic(idx.filename, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	idx.changed = false
+	return nil
+}
+
+// Len returns the number of documents in the index.
+func (idx *Index) Len() int {
+	return len(idx.Docs)
+}
+
+// Version returns the version of the specified document in the index.
+func (idx *Index) Version(id int64) (int, bool) {
+	di, ok := idx.Docs[id]
+	if !ok {
+		return 0, false
+	}
+	return di.Version, true
+}
+
+// Stamp returns the stamp, see Document, of the specified document in
+// the index.
+func (idx *Index) Stamp(id int64) (string, bool) {
+	di, ok := idx.Docs[id]
+	if !ok {
+		return "", false
+	}
+	return di.Stamp, true
+}
+
+// IDs returns the IDs of all of the documents in the index.
+func (idx *Index) IDs() []int64 {
+	ids := make([]int64, 0, len(idx.Docs))
+	for id := range idx.Docs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Add adds doc to the index, replacing any existing document with the
+// same ID.
+func (idx *Index) Add(doc Document) {
+	idx.Remove(doc.ID)
+	di := &docInfo{Version: doc.Version, Stamp: doc.Stamp, URI: doc.URI, Title: doc.Title}
+	terms := map[string]bool{}
+	for f := Field(0); f < numFields; f++ {
+		tokens := tokenize(doc.Fields[f])
+		di.Lengths[f] = int32(len(tokens))
+		idx.TotalLengths[f] += int64(len(tokens))
+		positions := map[string][]int32{}
+		for i, t := range tokens {
+			positions[t] = append(positions[t], int32(i))
+		}
+		for t, p := range positions {
+			idx.insert(t, posting{
+				Doc:       doc.ID,
+				Field:     f,
+				Positions: encodePositions(p),
+			})
+			terms[t] = true
+		}
+	}
+	for t := range terms {
+		di.Terms = append(di.Terms, t)
+	}
+	idx.Docs[doc.ID] = di
+	idx.changed = true
+}
+
+// docRange returns the range of postings for the specified document.
+func docRange(postings []posting, id int64) (int, int) {
+	lo := sort.Search(len(postings), func(i int) bool { return postings[i].Doc >= id })
+	hi := lo
+	for hi < len(postings) && postings[hi].Doc == id {
+		hi++
+	}
+	return lo, hi
+}
+
+// insert adds p to the postings for term t, after any existing
+// postings for the same document.
+func (idx *Index) insert(t string, p posting) {
+	postings := idx.Postings[t]
+	_, i := docRange(postings, p.Doc)
+	if i == len(postings) {
+		// Documents are typically added in order of their IDs.
+		idx.Postings[t] = append(postings, p)
+		return
+	}
+	postings = append(postings, posting{})
+	copy(postings[i+1:], postings[i:])
+	postings[i] = p
+	idx.Postings[t] = postings
+}
+
+// Remove removes the specified document from the index.
+func (idx *Index) Remove(id int64) {
+	di, ok := idx.Docs[id]
+	if !ok {
+		return
+	}
+	for _, t := range di.Terms {
+		postings := idx.Postings[t]
+		lo, hi := docRange(postings, id)
+		if lo == 0 && hi == len(postings) {
+			delete(idx.Postings, t)
+			continue
+		}
+		idx.Postings[t] = append(postings[:lo], postings[hi:]...)
+	}
+	for f := Field(0); f < numFields; f++ {
+		idx.TotalLengths[f] -= int64(di.Lengths[f])
+	}
+	delete(idx.Docs, id)
+	idx.changed = true
+}
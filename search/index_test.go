@@ -0,0 +1,95 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package search
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func newDoc(id int64, title, description string) Document {
+	doc := Document{ID: id, Version: 1, Title: title}
+	doc.Fields[Title] = title
+	doc.Fields[Description] = description
+	return doc
+}
+
+func resultIDs(results []Result) []int64 {
+	var ids []int64
+	for _, r := range results {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func checkSorted(t *testing.T, idx *Index) {
+	t.Helper()
+	for term, postings := range idx.Postings {
+		if !sort.SliceIsSorted(postings, func(i, j int) bool { return postings[i].Doc < postings[j].Doc }) {
+			t.Errorf("%v: postings are not sorted: %v", term, postings)
+		}
+	}
+}
+
+func TestIndexAddRemove(t *testing.T) {
+	idx := New(filepath.Join(t.TempDir(), "search.idx"))
+	// Add documents out of order.
+	for _, id := range []int64{5, 1, 3, 2, 4} {
+		idx.Add(newDoc(id, "pcr protocol", "amplify dna"))
+	}
+	checkSorted(t, idx)
+	search := func(q string) []int64 {
+		query, err := ParseQuery(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resultIDs(idx.Search(query, 0))
+	}
+	if got, want := search("pcr"), []int64{1, 2, 3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	idx.Remove(3)
+	idx.Remove(1)
+	idx.Remove(6)
+	// Replace an existing document.
+	idx.Add(newDoc(4, "western blot", "transfer proteins"))
+	checkSorted(t, idx)
+	if got, want := search("pcr"), []int64{2, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := search("blot"), []int64{4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, id := range []int64{2, 4, 5} {
+		idx.Remove(id)
+	}
+	if got, want := idx.Len(), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(idx.Postings), 0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := idx.TotalLengths, [numFields]int64{}; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestIndexSaveOpen(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "search.idx")
+	idx := New(filename)
+	idx.Add(newDoc(1, "pcr protocol", "amplify dna"))
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := idx.Version(1); !ok || v != 1 {
+		t.Errorf("got %v, %v, want 1, true", v, ok)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package search
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// BM25 parameters.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// anyField is used for clauses that are not restricted to a field.
+const anyField Field = numFields
+
+// clause represents a term, or phrase, that a document must contain,
+// optionally restricted to a specific field.
+type clause struct {
+	field Field
+	terms []string
+}
+
+// Query represents a parsed query. All of its clauses must match a
+// document for it to be returned.
+type Query struct {
+	clauses []clause
+}
+
+// ParseQuery parses a query. A query consists of terms and phrases,
+// enclosed in double quotes, each of which may be restricted to a
+// specific field using a prefix of the form <field>:, for example,
+// author:smith or title:"gel electrophoresis". The supported fields are
+// title, description, steps, materials, authors and keywords, and their
+// singular forms; any other prefix, such as that of a URL, is treated as
+// part of the term. A term that contains punctuation, such as covid-19,
+// is treated as a phrase.
+func ParseQuery(q string) (Query, error) {
+	var query Query
+	for len(q) > 0 {
+		q = strings.TrimLeft(q, " \t\n")
+		if len(q) == 0 {
+			break
+		}
+		field := anyField
+		if i := strings.IndexAny(q, ":\" \t\n"); i > 0 && q[i] == ':' {
+			// Prefixes that are not field names, such as http: or
+			// 10.17504:, are part of the term.
+			if f, ok := fieldAliases[strings.ToLower(q[:i])]; ok {
+				field = f
+				q = q[i+1:]
+			}
+		}
+		var text string
+		if strings.HasPrefix(q, `"`) {
+			end := strings.Index(q[1:], `"`)
+			if end < 0 {
+				return Query{}, fmt.Errorf("unterminated phrase: %s", q)
+			}
+			text, q = q[1:end+1], q[end+2:]
+		} else {
+			end := strings.IndexAny(q, " \t\n")
+			if end < 0 {
+				end = len(q)
+			}
+			text, q = q[:end], q[end:]
+		}
+		if terms := tokenize(text); len(terms) > 0 {
+			query.clauses = append(query.clauses, clause{field: field, terms: terms})
+		}
+	}
+	if len(query.clauses) == 0 {
+		return Query{}, fmt.Errorf("empty query")
+	}
+	return query, nil
+}
+
+func (c clause) String() string {
+	phrase := strings.Join(c.terms, " ")
+	if len(c.terms) > 1 {
+		phrase = `"` + phrase + `"`
+	}
+	if c.field == anyField {
+		return phrase
+	}
+	return c.field.String() + ":" + phrase
+}
+
+func (q Query) String() string {
+	parts := make([]string, len(q.clauses))
+	for i, c := range q.clauses {
+		parts[i] = c.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+// Result represents a document that matches a query.
+type Result struct {
+	ID      int64
+	Version int
+	URI     string
+	Title   string
+	Score   float64
+}
+
+type docField struct {
+	doc   int64
+	field Field
+}
+
+// occurrences returns the number of times that the clause's phrase
+// occurs in each document and field.
+func (idx *Index) occurrences(c clause) map[docField]int {
+	// Positions of each term, indexed by term, document and field.
+	positions := make([]map[docField][]int32, len(c.terms))
+	for i, t := range c.terms {
+		positions[i] = map[docField][]int32{}
+		for _, p := range idx.Postings[t] {
+			if c.field != anyField && p.Field != c.field {
+				continue
+			}
+			positions[i][docField{p.Doc, p.Field}] = decodePositions(p.Positions)
+		}
+	}
+	counts := map[docField]int{}
+	for df, first := range positions[0] {
+		if len(c.terms) == 1 {
+			counts[df] = len(first)
+			continue
+		}
+		n := 0
+	next:
+		for _, start := range first {
+			for i := 1; i < len(c.terms); i++ {
+				if !contains(positions[i][df], start+int32(i)) {
+					continue next
+				}
+			}
+			n++
+		}
+		if n > 0 {
+			counts[df] = n
+		}
+	}
+	return counts
+}
+
+func contains(positions []int32, p int32) bool {
+	i := sort.Search(len(positions), func(i int) bool { return positions[i] >= p })
+	return i < len(positions) && positions[i] == p
+}
+
+// idf returns the inverse document frequency of a clause, as computed
+// by BM25, given the number of documents that it occurs in.
+func (idx *Index) idf(docs int) float64 {
+	n := float64(len(idx.Docs))
+	return math.Log(1 + (n-float64(docs)+0.5)/(float64(docs)+0.5))
+}
+
+// Search returns the documents that match the query ranked by their
+// BM25 score, with each field's score weighted according to the
+// field's importance. At most limit results are returned if limit is
+// greater than zero.
+func (idx *Index) Search(q Query, limit int) []Result {
+	if len(idx.Docs) == 0 {
+		return nil
+	}
+	var avgLen [numFields]float64
+	for f := Field(0); f < numFields; f++ {
+		avgLen[f] = float64(idx.TotalLengths[f]) / float64(len(idx.Docs))
+	}
+	var scores map[int64]float64
+	for _, c := range q.clauses {
+		counts := idx.occurrences(c)
+		docs := map[int64]bool{}
+		for df := range counts {
+			docs[df.doc] = true
+		}
+		idf := idx.idf(len(docs))
+		clauseScores := map[int64]float64{}
+		for df, tf := range counts {
+			if scores != nil {
+				if _, ok := scores[df.doc]; !ok {
+					continue
+				}
+			}
+			norm := 1.0
+			if avgLen[df.field] > 0 {
+				norm = 1 - bm25B + bm25B*float64(idx.Docs[df.doc].Lengths[df.field])/avgLen[df.field]
+			}
+			tff := float64(tf)
+			clauseScores[df.doc] += fieldWeights[df.field] * idf * tff * (bm25K1 + 1) / (tff + bm25K1*norm)
+		}
+		if scores == nil {
+			scores = clauseScores
+			continue
+		}
+		for doc, s := range scores {
+			cs, ok := clauseScores[doc]
+			if !ok {
+				delete(scores, doc)
+				continue
+			}
+			scores[doc] = s + cs
+		}
+	}
+	results := make([]Result, 0, len(scores))
+	for doc, score := range scores {
+		di := idx.Docs[doc]
+		results = append(results, Result{
+			ID:      doc,
+			Version: di.Version,
+			URI:     di.URI,
+			Title:   di.Title,
+			Score:   score,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package search
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	for i, tc := range []struct {
+		input  string
+		output string
+	}{
+		{"pcr", "pcr"},
+		{"  PCR  dna ", "pcr dna"},
+		{`"gel electrophoresis"`, `"gel electrophoresis"`},
+		{`title:"gel electrophoresis" author:Smith`, `title:"gel electrophoresis" authors:smith`},
+		{"desc:buffer step:incubate material:taq keyword:dna", "description:buffer steps:incubate materials:taq keywords:dna"},
+		{"covid-19", `"covid 19"`},
+		// Prefixes that are not field names are part of the term.
+		{"http://example.com/x", `"http example com x"`},
+		{"10.17504:abc", `"10 17504 abc"`},
+		{"ratio 1:10", `ratio "1 10"`},
+		{"title:http://example.com", `title:"http example com"`},
+	} {
+		q, err := ParseQuery(tc.input)
+		if err != nil {
+			t.Errorf("%v: %v: %v", i, tc.input, err)
+			continue
+		}
+		if got, want := q.String(), tc.output; got != want {
+			t.Errorf("%v: %v: got %v, want %v", i, tc.input, got, want)
+		}
+	}
+	for i, tc := range []string{"", "  ", `"unterminated`, "title:", "-- ::"} {
+		if _, err := ParseQuery(tc); err == nil {
+			t.Errorf("%v: %q: expected an error", i, tc)
+		}
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	idx := New(filepath.Join(t.TempDir(), "search.idx"))
+	idx.Add(newDoc(1, "western blot", "pcr is mentioned once in a long description of many other things"))
+	idx.Add(newDoc(2, "pcr", "pcr pcr"))
+	idx.Add(newDoc(3, "pcr amplification", "amplify dna using pcr"))
+	idx.Add(newDoc(4, "gel electrophoresis", "run a gel after pcr amplification"))
+	idx.Add(newDoc(5, "unrelated", "nothing to see here"))
+	for i, tc := range []struct {
+		query string
+		limit int
+		ids   []int64
+	}{
+		{"pcr", 0, []int64{2, 3, 4, 1}},
+		{"pcr", 2, []int64{2, 3}},
+		{"title:pcr", 0, []int64{2, 3}},
+		{"pcr amplification", 0, []int64{3, 4}},
+		{`"pcr amplification"`, 0, []int64{3, 4}},
+		{`"amplification pcr"`, 0, nil},
+		{"description:gel", 0, []int64{4}},
+		{"missing", 0, nil},
+	} {
+		q, err := ParseQuery(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := resultIDs(idx.Search(q, tc.limit)), tc.ids; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: %v: got %v, want %v", i, tc.query, got, want)
+		}
+	}
+}
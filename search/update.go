@@ -0,0 +1,74 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package search
+
+import (
+	"context"
+
+	"github.com/cosnicolaou/protocolsio/store"
+)
+
+// UpdateStats records the changes made by Update.
+type UpdateStats struct {
+	Added, Updated, Removed, Failed int
+}
+
+// Update brings the index up to date with the latest version of every
+// protocol's details in st. Only protocols whose details have changed,
+// as determined by their store.Store.Stamp, since they were indexed are
+// read and parsed. Protocols whose details cannot be read or parsed are
+// reported via errFn and are otherwise ignored.
+func (idx *Index) Update(ctx context.Context, st store.Store, errFn func(id int64, err error)) (UpdateStats, error) {
+	var stats UpdateStats
+	ids, err := st.List(ctx, store.Detail)
+	if err != nil {
+		return stats, err
+	}
+	present := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		present[id] = true
+		key := store.Key{ID: id, Kind: store.Detail}
+		stamp, err := st.Stamp(ctx, key)
+		if err != nil {
+			stats.Failed++
+			errFn(id, err)
+			continue
+		}
+		indexed, ok := idx.Stamp(id)
+		if ok && indexed == stamp {
+			continue
+		}
+		buf, err := st.Get(ctx, key)
+		if err != nil {
+			stats.Failed++
+			errFn(id, err)
+			continue
+		}
+		p, err := store.ParseProtocol(store.Detail, buf)
+		if err != nil {
+			stats.Failed++
+			errFn(id, err)
+			continue
+		}
+		doc := DocumentFor(p)
+		doc.Stamp = stamp
+		idx.Add(doc)
+		if ok {
+			stats.Updated++
+		} else {
+			stats.Added++
+		}
+	}
+	for _, id := range idx.IDs() {
+		if !present[id] {
+			idx.Remove(id)
+			stats.Removed++
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package search
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cosnicolaou/protocolsio/store"
+)
+
+func detail(id int64, version int, title string) []byte {
+	return []byte(fmt.Sprintf(`{"payload":{"id":%v,"version_id":%v,"title":%q}}`, id, version, title))
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range []string{store.FilesystemBackend, store.KVBackend} {
+		dir := t.TempDir()
+		st, err := store.Open(backend, dir)
+		if err != nil {
+			t.Fatalf("%v: %v", backend, err)
+		}
+		put := func(id int64, version int, title string) {
+			if err := st.Put(ctx, store.Key{ID: id, Kind: store.Detail, Version: version}, detail(id, version, title)); err != nil {
+				t.Fatalf("%v: %v", backend, err)
+			}
+		}
+		idx := New(filepath.Join(dir, "search.idx"))
+		update := func(want UpdateStats) {
+			t.Helper()
+			stats, err := idx.Update(ctx, st, func(id int64, err error) {
+				t.Errorf("%v: %v: %v", backend, id, err)
+			})
+			if err != nil {
+				t.Fatalf("%v: %v", backend, err)
+			}
+			if got := stats; got != want {
+				t.Errorf("%v: got %+v, want %+v", backend, got, want)
+			}
+		}
+		search := func(query string) []int64 {
+			q, err := ParseQuery(query)
+			if err != nil {
+				t.Fatalf("%v: %v", backend, err)
+			}
+			return resultIDs(idx.Search(q, 0))
+		}
+		put(1, 1, "agarose gel")
+		put(2, 1, "western blot")
+		update(UpdateStats{Added: 2})
+		update(UpdateStats{})
+		// A change without a new version is re-indexed.
+		put(1, 1, "polyacrylamide gel")
+		update(UpdateStats{Updated: 1})
+		if got, want := search("polyacrylamide"), []int64{1}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", backend, got, want)
+		}
+		if got := search("agarose"); len(got) != 0 {
+			t.Errorf("%v: unexpected results: %v", backend, got)
+		}
+		put(2, 2, "western blot v2")
+		update(UpdateStats{Updated: 1})
+		if err := st.Delete(ctx, store.Key{ID: 2, Kind: store.Detail}); err != nil {
+			t.Fatalf("%v: %v", backend, err)
+		}
+		update(UpdateStats{Removed: 1})
+		st.Close()
+	}
+}
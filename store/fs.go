@@ -0,0 +1,455 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Filesystem is a Store that stores each object in a separate file in a
+// directory. Every version of an object is stored as
+// versions/<id>/<version>.<kind> and the latest version is also stored
+// as <id>.<kind>. The checksum of every file written is recorded in a
+// manifest so that corrupt files can be detected.
+type Filesystem struct {
+	dir      string
+	readOnly bool
+	manifest *manifest
+}
+
+// OpenFilesystem opens, creating if necessary, a Filesystem store in dir.
+// The manifest is compacted if it contains a large number of superseded
+// entries and hence the caller must ensure that no other process has the
+// store open for writing.
+func OpenFilesystem(dir string) (*Filesystem, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	m, err := openManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Filesystem{dir: dir, manifest: m}, nil
+}
+
+// OpenFilesystemReadOnly opens a Filesystem store in dir for reading
+// only; nothing is created or written in dir.
+func OpenFilesystemReadOnly(dir string) (*Filesystem, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Filesystem{dir: dir, readOnly: true, manifest: m}, nil
+}
+
+// versionName returns the slash separated name of the file used to
+// store a specific version of an object.
+func versionName(key Key) string {
+	return path.Join("versions", fmt.Sprintf("%06d", key.ID), fmt.Sprintf("%d.%s", key.Version, key.Kind))
+}
+
+func (fs *Filesystem) write(name string, data []byte) error {
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+	filename := filepath.Join(fs.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(filename, data, 0600); err != nil {
+		return err
+	}
+	return fs.manifest.record(name, data)
+}
+
+func (fs *Filesystem) read(name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(fs.dir, filepath.FromSlash(name)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%v: %w", name, ErrNotFound)
+		}
+		return nil, err
+	}
+	if err := fs.manifest.verify(name, data); err != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrCorrupt)
+	}
+	return data, nil
+}
+
+func (fs *Filesystem) remove(name string) error {
+	if fs.readOnly {
+		return ErrReadOnly
+	}
+	err := os.Remove(filepath.Join(fs.dir, filepath.FromSlash(name)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return fs.manifest.forget(name)
+}
+
+// latestVersion returns the version of the object stored as the latest
+// version, or 0 if there is no such object or it cannot be read.
+func (fs *Filesystem) latestVersion(id int64, kind Kind) int {
+	data, err := fs.read(Key{ID: id, Kind: kind}.Name())
+	if err != nil {
+		return 0
+	}
+	version, _ := ParseVersion(kind, data)
+	return version
+}
+
+// Put implements Store.
+func (fs *Filesystem) Put(ctx context.Context, key Key, data []byte) error {
+	if err := checkPut(key); err != nil {
+		return err
+	}
+	if err := fs.write(versionName(key), data); err != nil {
+		return err
+	}
+	if fs.latestVersion(key.ID, key.Kind) > key.Version {
+		return nil
+	}
+	return fs.write(key.Name(), data)
+}
+
+// Get implements Store.
+func (fs *Filesystem) Get(ctx context.Context, key Key) ([]byte, error) {
+	if key.Version == 0 {
+		return fs.read(key.Name())
+	}
+	data, err := fs.read(versionName(key))
+	if !errors.Is(err, ErrNotFound) {
+		return data, err
+	}
+	// Objects written before version history was kept are only
+	// stored as the latest version.
+	data, err = fs.read(key.Name())
+	if err != nil {
+		return nil, err
+	}
+	version, err := ParseVersion(key.Kind, data)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v: %w", key.Name(), err, ErrCorrupt)
+	}
+	if version != key.Version {
+		return nil, fmt.Errorf("%v: version %v: %w", key.Name(), key.Version, ErrNotFound)
+	}
+	return data, nil
+}
+
+// List implements Store.
+func (fs *Filesystem) List(ctx context.Context, kind Kind) ([]int64, error) {
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+	suffix := "." + string(kind)
+	var ids []int64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(name, suffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// Versions implements Store.
+func (fs *Filesystem) Versions(ctx context.Context, id int64, kind Kind) ([]int, error) {
+	dir := filepath.Join(fs.dir, "versions", fmt.Sprintf("%06d", id))
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	suffix := "." + string(kind)
+	var versions []int
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		if v, err := strconv.Atoi(strings.TrimSuffix(name, suffix)); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) > 0 {
+		// The latest version is always also stored in the versions
+		// directory.
+		sort.Ints(versions)
+		return versions, nil
+	}
+	// Objects written before version history was kept are only
+	// stored as the latest version.
+	if latest := fs.latestVersion(id, kind); latest != 0 {
+		versions = append(versions, latest)
+	}
+	return versions, nil
+}
+
+// Stamp implements Store. The checksum recorded in the manifest is used
+// if there is one, otherwise the size and modification time of the file.
+func (fs *Filesystem) Stamp(ctx context.Context, key Key) (string, error) {
+	name := key.Name()
+	if key.Version != 0 {
+		name = versionName(key)
+	}
+	if sum, ok := fs.manifest.sum(name); ok {
+		return "sha256:" + sum, nil
+	}
+	fi, err := os.Stat(filepath.Join(fs.dir, filepath.FromSlash(name)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("%v: %w", name, ErrNotFound)
+		}
+		return "", err
+	}
+	return fmt.Sprintf("%v:%v", fi.Size(), fi.ModTime().UnixNano()), nil
+}
+
+// Delete implements Store.
+func (fs *Filesystem) Delete(ctx context.Context, key Key) error {
+	versions, err := fs.Versions(ctx, key.ID, key.Kind)
+	if err != nil {
+		return err
+	}
+	latest := Key{ID: key.ID, Kind: key.Kind}.Name()
+	if key.Version == 0 {
+		for _, v := range versions {
+			if err := fs.remove(versionName(Key{ID: key.ID, Kind: key.Kind, Version: v})); err != nil {
+				return err
+			}
+		}
+		return fs.remove(latest)
+	}
+	if err := fs.remove(versionName(key)); err != nil {
+		return err
+	}
+	if fs.latestVersion(key.ID, key.Kind) != key.Version {
+		return nil
+	}
+	if err := fs.remove(latest); err != nil {
+		return err
+	}
+	// Make the most recent of the remaining versions the latest.
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i] == key.Version {
+			continue
+		}
+		data, err := fs.read(versionName(Key{ID: key.ID, Kind: key.Kind, Version: versions[i]}))
+		if err != nil {
+			return err
+		}
+		return fs.write(latest, data)
+	}
+	return nil
+}
+
+// Close implements Store.
+func (fs *Filesystem) Close() error {
+	return fs.manifest.close()
+}
+
+// WriteFileAtomic writes data to filename such that filename contains
+// either its previous contents or all of data, even if the process
+// crashes: data is written to a temporary file in the same directory
+// which is synced and then renamed to filename.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+const (
+	manifestFilename  = "MANIFEST"
+	manifestTombstone = "-"
+)
+
+// manifest records the sha256 checksum of every file written to the
+// store so that files that have been corrupted, or only partially
+// written, can be detected. It is stored as an append-only file in the
+// same format as that used by sha256sum, with later entries for a given
+// file superseding earlier ones. Removed files are recorded using a
+// tombstone entry with a checksum of manifestTombstone. The file is
+// compacted when it is opened for writing if it contains a large number
+// of superseded entries.
+type manifest struct {
+	filename string
+
+	mu    sync.Mutex
+	sums  map[string]string
+	lines int
+	f     *os.File
+}
+
+// readManifest reads the manifest in dir, if any, for use by a read-only
+// store.
+func readManifest(dir string) (*manifest, error) {
+	m := &manifest{
+		filename: filepath.Join(dir, manifestFilename),
+		sums:     map[string]string{},
+	}
+	if err := m.read(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func openManifest(dir string) (*manifest, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if m.lines > 1000 && m.lines > 2*len(m.sums) {
+		if err := m.compact(); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(m.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	m.f = f
+	return m, nil
+}
+
+func (m *manifest) read() error {
+	f, err := os.Open(m.filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		sum, name, ok := strings.Cut(sc.Text(), "  ")
+		switch {
+		case ok && sum == manifestTombstone:
+			delete(m.sums, name)
+		case !ok || len(sum) != sha256.Size*2:
+			// Ignore partially written lines.
+			continue
+		default:
+			m.sums[name] = sum
+		}
+		m.lines++
+	}
+	return sc.Err()
+}
+
+func (m *manifest) compact() error {
+	var out strings.Builder
+	for name, sum := range m.sums {
+		fmt.Fprintf(&out, "%s  %s\n", sum, name)
+	}
+	m.lines = len(m.sums)
+	return WriteFileAtomic(m.filename, []byte(out.String()), 0600)
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// record records the checksum of data as written to the named file.
+func (m *manifest) record(name string, data []byte) error {
+	sum := checksum(data)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sums[name] == sum {
+		return nil
+	}
+	if _, err := fmt.Fprintf(m.f, "%s  %s\n", sum, name); err != nil {
+		return err
+	}
+	m.sums[name] = sum
+	m.lines++
+	return nil
+}
+
+// sum returns the checksum recorded for the named file, if any.
+func (m *manifest) sum(name string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sum, ok := m.sums[name]
+	return sum, ok
+}
+
+// verify returns an error if data does not match the checksum recorded
+// for the named file. Files with no recorded checksum, such as those
+// written by earlier versions, are assumed to be valid.
+func (m *manifest) verify(name string, data []byte) error {
+	m.mu.Lock()
+	want, ok := m.sums[name]
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if got := checksum(data); got != want {
+		return fmt.Errorf("%v: checksum mismatch: %v != %v", name, got, want)
+	}
+	return nil
+}
+
+// forget removes any checksum recorded for the named file.
+func (m *manifest) forget(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sums[name]; !ok {
+		return nil
+	}
+	if _, err := fmt.Fprintf(m.f, "%s  %s\n", manifestTombstone, name); err != nil {
+		return err
+	}
+	delete(m.sums, name)
+	m.lines++
+	return nil
+}
+
+func (m *manifest) close() error {
+	if m.f == nil {
+		return nil
+	}
+	return m.f.Close()
+}
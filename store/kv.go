@@ -0,0 +1,490 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// KVFilename is the name of the file used by the KV backend.
+const KVFilename = "protocols.kv"
+
+const (
+	kvMagic      = "PIOKV01\n"
+	kvHeaderSize = 4 + 1 + 2 + 4 // crc, op, key length, value length.
+	kvOpPut      = 1
+	kvOpDelete   = 2
+)
+
+var kvCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// KV is a Store that stores all objects in a single, append-only, file.
+// Each record in the file contains a checksum so that partially written
+// records, for example those written when the process crashed, can be
+// detected and discarded. Only the final record can be partially
+// written, that is, one that extends to the end of the file and is not
+// followed by a valid record; OpenKV fails with an error that wraps
+// ErrCorrupt if any other record is found to be corrupt. An index of the file is built in memory when it
+// is opened. The file is compacted when it is closed if a large fraction
+// of it is occupied by objects that have been replaced or deleted.
+//
+// Only a single process may write to a KV store at a time, but other
+// processes may read it concurrently.
+type KV struct {
+	filename string
+	readOnly bool
+
+	mu    sync.Mutex
+	f     *os.File
+	end   int64 // offset of the end of the last valid record.
+	live  int64 // number of bytes occupied by live records.
+	dirty bool
+	index map[kvKey][]kvEntry // sorted by version.
+}
+
+type kvKey struct {
+	kind Kind
+	id   int64
+}
+
+type kvEntry struct {
+	version int
+	offset  int64 // offset of the record.
+	size    int64 // size of the record.
+}
+
+func kvRecordKey(key Key) string {
+	return fmt.Sprintf("%s/%d/%d", key.Kind, key.ID, key.Version)
+}
+
+func parseKVRecordKey(s string) (Key, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return Key{}, fmt.Errorf("invalid key: %q", s)
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Key{}, fmt.Errorf("invalid key: %q: %v", s, err)
+	}
+	version, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return Key{}, fmt.Errorf("invalid key: %q: %v", s, err)
+	}
+	return Key{ID: id, Kind: Kind(parts[0]), Version: version}, nil
+}
+
+// OpenKV opens, creating if necessary, the KV store in filename. Only a
+// single process may have a KV store open using OpenKV at a time and
+// the store may be compacted when it is closed.
+func OpenKV(filename string) (*KV, error) {
+	return openKV(filename, false)
+}
+
+// OpenKVReadOnly opens the existing KV store in filename for reading
+// only. The file is not modified and Put and Delete return ErrReadOnly.
+// Objects written after the store is opened are not visible.
+func OpenKVReadOnly(filename string) (*KV, error) {
+	return openKV(filename, true)
+}
+
+func openKV(filename string, readOnly bool) (*KV, error) {
+	var f *os.File
+	var err error
+	if readOnly {
+		f, err = os.Open(filename)
+	} else {
+		f, err = os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	kv := &KV{filename: filename, readOnly: readOnly, f: f, index: map[kvKey][]kvEntry{}}
+	if err := kv.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%v: %w", filename, err)
+	}
+	return kv, nil
+}
+
+func (kv *KV) load() error {
+	fi, err := kv.f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 {
+		if kv.readOnly {
+			// The store is being created by another process.
+			return nil
+		}
+		if _, err := kv.f.WriteAt([]byte(kvMagic), 0); err != nil {
+			return err
+		}
+		kv.end = int64(len(kvMagic))
+		return kv.f.Sync()
+	}
+	rd := bufio.NewReader(io.NewSectionReader(kv.f, 0, fi.Size()))
+	magic := make([]byte, len(kvMagic))
+	if _, err := io.ReadFull(rd, magic); err != nil || string(magic) != kvMagic {
+		return fmt.Errorf("not a KV store")
+	}
+	offset := int64(len(kvMagic))
+	for {
+		op, key, size, err := kv.readRecord(rd, fi.Size()-offset)
+		if err == nil {
+			kv.apply(op, key, kvEntry{version: key.Version, offset: offset, size: size})
+			offset += size
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, ErrCorrupt) {
+			return err
+		}
+		torn, terr := kv.tornTail(offset, size, fi.Size())
+		if terr != nil {
+			return terr
+		}
+		if !torn {
+			// A damaged record that is followed by other records cannot
+			// have been partially written and is not ignored since doing
+			// so would allow it, and the records that follow it, to be
+			// overwritten.
+			if !errors.Is(err, ErrCorrupt) {
+				err = fmt.Errorf("%v: %w", err, ErrCorrupt)
+			}
+			return fmt.Errorf("record at offset %v: %w", offset, err)
+		}
+		break
+	}
+	kv.end = offset
+	if kv.readOnly || kv.end == fi.Size() {
+		return nil
+	}
+	// Discard any partially written final record.
+	if err := kv.f.Truncate(kv.end); err != nil {
+		return err
+	}
+	return kv.f.Sync()
+}
+
+// tornTail returns true if the damaged record at offset, whose header
+// claims it is size bytes long, is a partially written final record:
+// that is, it extends to, or beyond, the end of the file and no valid
+// record follows it.
+func (kv *KV) tornTail(offset, size, fileSize int64) (bool, error) {
+	if offset+size < fileSize {
+		return false, nil
+	}
+	rest := make([]byte, fileSize-offset)
+	if _, err := kv.f.ReadAt(rest, offset); err != nil {
+		return false, err
+	}
+	for i := 1; i+kvHeaderSize <= len(rest); i++ {
+		if validKVRecord(rest[i:]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// validKVRecord returns true if buf starts with a complete record that
+// passes verification.
+func validKVRecord(buf []byte) bool {
+	if len(buf) < kvHeaderSize {
+		return false
+	}
+	keyLen := int(binary.BigEndian.Uint16(buf[5:]))
+	valLen := int64(binary.BigEndian.Uint32(buf[7:]))
+	size := int64(kvHeaderSize+keyLen) + valLen
+	if size > int64(len(buf)) {
+		return false
+	}
+	if binary.BigEndian.Uint32(buf) != crc32.Checksum(buf[4:size], kvCRCTable) {
+		return false
+	}
+	_, err := parseKVRecordKey(string(buf[kvHeaderSize : kvHeaderSize+keyLen]))
+	return err == nil
+}
+
+// readRecord reads and verifies the next record. It returns io.EOF if
+// there are no more records, io.ErrUnexpectedEOF if the record is
+// truncated, or is larger than remaining, and an error that wraps
+// ErrCorrupt if it fails verification. The size of the record, as
+// claimed by its header, is returned along with any error other than
+// io.EOF.
+func (kv *KV) readRecord(rd io.Reader, remaining int64) (byte, Key, int64, error) {
+	hdr := make([]byte, kvHeaderSize)
+	if _, err := io.ReadFull(rd, hdr); err != nil {
+		return 0, Key{}, kvHeaderSize, err
+	}
+	op := hdr[4]
+	keyLen := int(binary.BigEndian.Uint16(hdr[5:]))
+	valLen := int64(binary.BigEndian.Uint32(hdr[7:]))
+	size := int64(kvHeaderSize+keyLen) + valLen
+	if size > remaining {
+		return 0, Key{}, size, io.ErrUnexpectedEOF
+	}
+	body := make([]byte, size-kvHeaderSize)
+	if _, err := io.ReadFull(rd, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, Key{}, size, err
+	}
+	crc := crc32.Update(crc32.Checksum(hdr[4:], kvCRCTable), kvCRCTable, body)
+	if crc != binary.BigEndian.Uint32(hdr) {
+		return 0, Key{}, size, fmt.Errorf("checksum mismatch: %w", ErrCorrupt)
+	}
+	key, err := parseKVRecordKey(string(body[:keyLen]))
+	if err != nil {
+		return 0, Key{}, size, fmt.Errorf("%v: %w", err, ErrCorrupt)
+	}
+	return op, key, size, nil
+}
+
+func encodeKVRecord(op byte, key string, value []byte) []byte {
+	buf := make([]byte, kvHeaderSize+len(key)+len(value))
+	buf[4] = op
+	binary.BigEndian.PutUint16(buf[5:], uint16(len(key)))
+	binary.BigEndian.PutUint32(buf[7:], uint32(len(value)))
+	copy(buf[kvHeaderSize:], key)
+	copy(buf[kvHeaderSize+len(key):], value)
+	binary.BigEndian.PutUint32(buf, crc32.Checksum(buf[4:], kvCRCTable))
+	return buf
+}
+
+// apply updates the index to reflect a record.
+func (kv *KV) apply(op byte, key Key, entry kvEntry) {
+	ik := kvKey{kind: key.Kind, id: key.ID}
+	entries := kv.index[ik]
+	// Remove any existing entry for the same version.
+	for i, e := range entries {
+		if e.version == key.Version {
+			kv.live -= e.size
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	if op == kvOpPut {
+		entries = append(entries, entry)
+		sort.Slice(entries, func(i, j int) bool { return entries[i].version < entries[j].version })
+		kv.live += entry.size
+	}
+	if len(entries) == 0 {
+		delete(kv.index, ik)
+		return
+	}
+	kv.index[ik] = entries
+}
+
+func (kv *KV) append(op byte, key Key, value []byte) error {
+	if kv.readOnly {
+		return ErrReadOnly
+	}
+	if len(value) > 1<<32-1 {
+		return fmt.Errorf("%v: value too large: %v bytes", key.Name(), len(value))
+	}
+	rec := encodeKVRecord(op, kvRecordKey(key), value)
+	if _, err := kv.f.WriteAt(rec, kv.end); err != nil {
+		return err
+	}
+	if err := kv.f.Sync(); err != nil {
+		return err
+	}
+	kv.apply(op, key, kvEntry{version: key.Version, offset: kv.end, size: int64(len(rec))})
+	kv.end += int64(len(rec))
+	kv.dirty = true
+	return nil
+}
+
+// Put implements Store.
+func (kv *KV) Put(ctx context.Context, key Key, data []byte) error {
+	if err := checkPut(key); err != nil {
+		return err
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.append(kvOpPut, key, data)
+}
+
+// lookup returns the entry for key, the latest version if key.Version
+// is zero.
+func (kv *KV) lookup(key Key) (kvEntry, bool) {
+	entries := kv.index[kvKey{kind: key.Kind, id: key.ID}]
+	if len(entries) == 0 {
+		return kvEntry{}, false
+	}
+	if key.Version == 0 {
+		return entries[len(entries)-1], true
+	}
+	for _, e := range entries {
+		if e.version == key.Version {
+			return e, true
+		}
+	}
+	return kvEntry{}, false
+}
+
+// Get implements Store.
+func (kv *KV) Get(ctx context.Context, key Key) ([]byte, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	entry, ok := kv.lookup(key)
+	if !ok {
+		return nil, fmt.Errorf("%v: %w", key.Name(), ErrNotFound)
+	}
+	rec := make([]byte, entry.size)
+	if _, err := kv.f.ReadAt(rec, entry.offset); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(rec) != crc32.Checksum(rec[4:], kvCRCTable) {
+		return nil, fmt.Errorf("%v: checksum mismatch: %w", key.Name(), ErrCorrupt)
+	}
+	keyLen := int(binary.BigEndian.Uint16(rec[5:]))
+	return rec[kvHeaderSize+keyLen:], nil
+}
+
+// List implements Store.
+func (kv *KV) List(ctx context.Context, kind Kind) ([]int64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	var ids []int64
+	for k := range kv.index {
+		if k.kind == kind {
+			ids = append(ids, k.id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// Versions implements Store.
+func (kv *KV) Versions(ctx context.Context, id int64, kind Kind) ([]int, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	var versions []int
+	for _, e := range kv.index[kvKey{kind: kind, id: id}] {
+		versions = append(versions, e.version)
+	}
+	return versions, nil
+}
+
+// Stamp implements Store. The checksum and size of the object's record
+// are used.
+func (kv *KV) Stamp(ctx context.Context, key Key) (string, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	entry, ok := kv.lookup(key)
+	if !ok {
+		return "", fmt.Errorf("%v: %w", key.Name(), ErrNotFound)
+	}
+	crc := make([]byte, 4)
+	if _, err := kv.f.ReadAt(crc, entry.offset); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("crc32c:%08x:%v", binary.BigEndian.Uint32(crc), entry.size), nil
+}
+
+// Delete implements Store.
+func (kv *KV) Delete(ctx context.Context, key Key) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	var versions []int
+	for _, e := range kv.index[kvKey{kind: key.Kind, id: key.ID}] {
+		if key.Version == 0 || e.version == key.Version {
+			versions = append(versions, e.version)
+		}
+	}
+	for _, v := range versions {
+		if err := kv.append(kvOpDelete, Key{ID: key.ID, Kind: key.Kind, Version: v}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compact rewrites the store so that it contains only live objects.
+func (kv *KV) Compact() error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.compact()
+}
+
+func (kv *KV) compact() error {
+	if kv.readOnly {
+		return ErrReadOnly
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(kv.filename), "."+filepath.Base(kv.filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	wr := bufio.NewWriter(tmp)
+	wr.WriteString(kvMagic) //nolint:errcheck
+	index := make(map[kvKey][]kvEntry, len(kv.index))
+	offset := int64(len(kvMagic))
+	for k, entries := range kv.index {
+		for _, e := range entries {
+			rec := make([]byte, e.size)
+			if _, err := kv.f.ReadAt(rec, e.offset); err != nil {
+				tmp.Close()
+				return err
+			}
+			if _, err := wr.Write(rec); err != nil {
+				tmp.Close()
+				return err
+			}
+			index[k] = append(index[k], kvEntry{version: e.version, offset: offset, size: e.size})
+			offset += e.size
+		}
+	}
+	err = wr.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), kv.filename)
+	}
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(kv.filename, os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	kv.f.Close()
+	kv.f, kv.index, kv.end, kv.live = f, index, offset, offset-int64(len(kvMagic))
+	return nil
+}
+
+// Close implements Store.
+func (kv *KV) Close() error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	var err error
+	if garbage := kv.end - kv.live; kv.dirty && garbage > 1<<20 && garbage > kv.live {
+		err = kv.compact()
+	}
+	if cerr := kv.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,162 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeKV creates a KV store containing n detail objects and returns
+// the offset of the start of each record and the size of the file.
+func writeKV(t *testing.T, filename string, n int) ([]int64, int64) {
+	ctx := context.Background()
+	kv, err := OpenKV(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	for i := 1; i <= n; i++ {
+		offsets = append(offsets, kv.end)
+		if err := kv.Put(ctx, Key{ID: int64(i), Kind: Detail, Version: 1}, []byte(fmt.Sprintf(`{"id":%v}`, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	end := kv.end
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return offsets, end
+}
+
+func TestKVRecovery(t *testing.T) {
+	ctx := context.Background()
+	for i, tc := range []struct {
+		name    string
+		damage  func(f *os.File, offsets []int64, end int64) error
+		ids     []int64
+		corrupt bool
+	}{
+		{"intact", func(*os.File, []int64, int64) error { return nil }, []int64{1, 2, 3}, false},
+		{"truncated header", func(f *os.File, offsets []int64, end int64) error {
+			return f.Truncate(offsets[2] + 3)
+		}, []int64{1, 2}, false},
+		{"truncated body", func(f *os.File, offsets []int64, end int64) error {
+			return f.Truncate(end - 1)
+		}, []int64{1, 2}, false},
+		{"corrupt final record", func(f *os.File, offsets []int64, end int64) error {
+			_, err := f.WriteAt([]byte("x"), end-1)
+			return err
+		}, []int64{1, 2}, false},
+		{"implausible length", func(f *os.File, offsets []int64, end int64) error {
+			_, err := f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, offsets[2]+7)
+			return err
+		}, []int64{1, 2}, false},
+		{"implausible length in middle record", func(f *os.File, offsets []int64, end int64) error {
+			_, err := f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, offsets[1]+7)
+			return err
+		}, nil, true},
+		{"truncated middle record", func(f *os.File, offsets []int64, end int64) error {
+			// Simulate a record that was partially written before
+			// the records that follow it were appended.
+			_, err := f.WriteAt([]byte{0, 0, 0x10, 0}, offsets[1]+7)
+			return err
+		}, nil, true},
+		{"corrupt first record", func(f *os.File, offsets []int64, end int64) error {
+			_, err := f.WriteAt([]byte("x"), offsets[1]-1)
+			return err
+		}, nil, true},
+		{"corrupt middle record", func(f *os.File, offsets []int64, end int64) error {
+			_, err := f.WriteAt([]byte{0}, offsets[1])
+			return err
+		}, nil, true},
+	} {
+		filename := filepath.Join(t.TempDir(), KVFilename)
+		offsets, end := writeKV(t, filename, 3)
+		f, err := os.OpenFile(filename, os.O_RDWR, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tc.damage(f, offsets, end); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		kv, err := OpenKV(filename)
+		if tc.corrupt {
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("%v: %v: missing or unexpected error: %v", i, tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v: %v", i, tc.name, err)
+			continue
+		}
+		ids, _ := kv.List(ctx, Detail)
+		if got, want := fmt.Sprint(ids), fmt.Sprint(tc.ids); got != want {
+			t.Errorf("%v: %v: got %v, want %v", i, tc.name, got, want)
+		}
+		// A torn record is discarded when the store is opened.
+		if fi, err := os.Stat(filename); err != nil || fi.Size() != kv.end {
+			t.Errorf("%v: %v: torn record was not discarded: %v", i, tc.name, err)
+		}
+		if err := kv.Put(ctx, Key{ID: 4, Kind: Detail, Version: 1}, []byte(`{"id":4}`)); err != nil {
+			t.Fatal(err)
+		}
+		kv.Close()
+		if kv, err = OpenKV(filename); err != nil {
+			t.Errorf("%v: %v: %v", i, tc.name, err)
+			continue
+		}
+		ids, _ = kv.List(ctx, Detail)
+		if got, want := fmt.Sprint(ids), fmt.Sprint(append(tc.ids, 4)); got != want {
+			t.Errorf("%v: %v: got %v, want %v", i, tc.name, got, want)
+		}
+		kv.Close()
+	}
+}
+
+func TestKVReadOnly(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), KVFilename)
+	if _, err := OpenKVReadOnly(filename); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing or unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filename); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("%v was created: %v", filename, err)
+	}
+	writeKV(t, filename, 2)
+	before, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv, err := OpenKVReadOnly(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf, err := kv.Get(ctx, Key{ID: 2, Kind: Detail}); err != nil || string(buf) != `{"id":2}` {
+		t.Errorf("got %s, %v", buf, err)
+	}
+	if err := kv.Put(ctx, Key{ID: 3, Kind: Detail, Version: 1}, []byte(`{}`)); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("missing or unexpected error: %v", err)
+	}
+	if err := kv.Delete(ctx, Key{ID: 1, Kind: Detail}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("missing or unexpected error: %v", err)
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Errorf("%v was modified", filename)
+	}
+}
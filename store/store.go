@@ -0,0 +1,190 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+// Package store provides storage for downloaded protocols.io objects.
+// Objects are addressed by protocol ID, their kind, that is, whether
+// they were obtained from the list or get APIs, and their version.
+// Implementations are provided that store each object in a separate
+// file in a directory and that store all objects in a single file.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cosnicolaou/protocolsio/api"
+)
+
+var (
+	// ErrNotFound is returned when an object does not exist in the store.
+	ErrNotFound = errors.New("not found")
+	// ErrCorrupt is returned when an object in the store fails its
+	// integrity check, typically because it was only partially written.
+	ErrCorrupt = errors.New("corrupt")
+	// ErrReadOnly is returned when an attempt is made to modify a store
+	// that was opened read-only.
+	ErrReadOnly = errors.New("store is read-only")
+	// ErrNoVersion is returned by Put for a key with a Version of zero.
+	ErrNoVersion = errors.New("no version specified")
+)
+
+// Kind represents the kind of an object in the store.
+type Kind string
+
+const (
+	// List objects are the ListEntry for a protocol as returned by
+	// the list API.
+	List Kind = "list"
+	// Detail objects are the full protocol as returned by the get API.
+	Detail Kind = "detail"
+)
+
+// Kinds returns all of the supported kinds.
+func Kinds() []Kind {
+	return []Kind{List, Detail}
+}
+
+// Key identifies an object in the store.
+type Key struct {
+	ID   int64
+	Kind Kind
+	// Version is the version of the protocol, a Version of zero refers
+	// to the latest version and may not be used with Put.
+	Version int
+}
+
+// Name returns the name conventionally used for the latest version of
+// the object.
+func (k Key) Name() string {
+	return fmt.Sprintf("%06d.%s", k.ID, k.Kind)
+}
+
+// Store represents a store of protocols.io objects. All implementations
+// may be used concurrently.
+type Store interface {
+	// Put stores data for the specified key, replacing any existing
+	// object with the same key. The key's Version must be the version
+	// of the protocol contained in data, a Version of zero is rejected
+	// with ErrNoVersion; all versions of an object are retained and the
+	// one with the highest version is the latest.
+	Put(ctx context.Context, key Key, data []byte) error
+	// Get returns the object for key, ErrNotFound if there is no such
+	// object or ErrCorrupt if the object fails its integrity check.
+	Get(ctx context.Context, key Key) ([]byte, error)
+	// List returns the IDs, in ascending order, of all protocols that
+	// have an object of the specified kind.
+	List(ctx context.Context, kind Kind) ([]int64, error)
+	// Versions returns the versions, in ascending order, stored for
+	// the specified protocol and kind.
+	Versions(ctx context.Context, id int64, kind Kind) ([]int, error)
+	// Stamp returns a value that changes whenever the object for key
+	// changes, without reading the object, so that changes can be
+	// detected cheaply. It returns ErrNotFound if there is no such
+	// object.
+	Stamp(ctx context.Context, key Key) (string, error)
+	// Delete deletes the object for key; a Version of zero deletes all
+	// versions.
+	Delete(ctx context.Context, key Key) error
+	// Close releases any resources used by the store.
+	Close() error
+}
+
+// Supported backends.
+const (
+	FilesystemBackend = "filesystem"
+	KVBackend         = "kv"
+)
+
+// Open opens, creating if necessary, a store of the specified backend in
+// dir. If backend is empty, the KV backend is used if dir contains a KV
+// store, and the filesystem backend otherwise. The caller must ensure
+// that no other process has the store open for writing, in particular,
+// it may be compacted when opened.
+func Open(backend, dir string) (Store, error) {
+	backend, err := selectBackend(backend, dir)
+	if err != nil {
+		return nil, err
+	}
+	switch backend {
+	case FilesystemBackend:
+		return OpenFilesystem(dir)
+	default:
+		return OpenKV(filepath.Join(dir, KVFilename))
+	}
+}
+
+// OpenReadOnly opens an existing store of the specified backend in dir
+// for reading only. Nothing is created or written in dir and it is safe
+// to use whilst another process is writing to the store. Put and
+// Delete return ErrReadOnly.
+func OpenReadOnly(backend, dir string) (Store, error) {
+	backend, err := selectBackend(backend, dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	switch backend {
+	case FilesystemBackend:
+		return OpenFilesystemReadOnly(dir)
+	default:
+		return OpenKVReadOnly(filepath.Join(dir, KVFilename))
+	}
+}
+
+func selectBackend(backend, dir string) (string, error) {
+	switch backend {
+	case FilesystemBackend, KVBackend:
+		return backend, nil
+	case "":
+		if _, err := os.Stat(filepath.Join(dir, KVFilename)); err == nil {
+			return KVBackend, nil
+		}
+		return FilesystemBackend, nil
+	}
+	return "", fmt.Errorf("unsupported store backend: %q, use one of %q or %q", backend, FilesystemBackend, KVBackend)
+}
+
+// ListEntry is the format used to store list objects.
+type ListEntry struct {
+	Extras json.RawMessage
+	Item   json.RawMessage
+}
+
+// checkPut returns an error if key may not be used with Put.
+func checkPut(key Key) error {
+	if key.Version == 0 {
+		return fmt.Errorf("%v: %w", key.Name(), ErrNoVersion)
+	}
+	return nil
+}
+
+// ParseVersion returns the version of the protocol contained in data,
+// an object of the specified kind.
+func ParseVersion(kind Kind, data []byte) (int, error) {
+	p, err := ParseProtocol(kind, data)
+	return p.VersionID, err
+}
+
+// ParseProtocol parses data, an object of the specified kind.
+func ParseProtocol(kind Kind, data []byte) (api.Protocol, error) {
+	var p api.Protocol
+	switch kind {
+	case List:
+		var entry ListEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return p, err
+		}
+		err := json.Unmarshal(entry.Item, &p)
+		return p, err
+	case Detail:
+		return api.ParsePayload[api.Protocol](data)
+	}
+	return p, fmt.Errorf("unsupported kind: %q", kind)
+}
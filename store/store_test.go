@@ -0,0 +1,219 @@
+// Copyright 2022 Cosmos Nicolaou. All rights reserved.
+// Use of this source code is governed by the Apache-2.0
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func detail(id int64, version int) []byte {
+	return []byte(fmt.Sprintf(`{"payload":{"id":%v,"version_id":%v}}`, id, version))
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range []string{FilesystemBackend, KVBackend} {
+		dir := t.TempDir()
+		st, err := Open(backend, dir)
+		if err != nil {
+			t.Fatalf("%v: %v", backend, err)
+		}
+		for _, put := range []struct {
+			id      int64
+			version int
+		}{{2, 1}, {1, 1}, {1, 3}, {1, 2}} {
+			if err := st.Put(ctx, Key{ID: put.id, Kind: Detail, Version: put.version}, detail(put.id, put.version)); err != nil {
+				t.Fatalf("%v: %v", backend, err)
+			}
+		}
+		if err := st.Close(); err != nil {
+			t.Fatalf("%v: %v", backend, err)
+		}
+		// The backend is determined by the contents of dir.
+		if st, err = Open("", dir); err != nil {
+			t.Fatalf("%v: %v", backend, err)
+		}
+		get := func(key Key) string {
+			data, err := st.Get(ctx, key)
+			if err != nil {
+				return err.Error()
+			}
+			return string(data)
+		}
+		ids, err := st.List(ctx, Detail)
+		if err != nil {
+			t.Fatalf("%v: %v", backend, err)
+		}
+		if got, want := ids, []int64{1, 2}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", backend, got, want)
+		}
+		if ids, _ := st.List(ctx, List); len(ids) != 0 {
+			t.Errorf("%v: unexpected list objects: %v", backend, ids)
+		}
+		versions, err := st.Versions(ctx, 1, Detail)
+		if err != nil {
+			t.Fatalf("%v: %v", backend, err)
+		}
+		if got, want := versions, []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", backend, got, want)
+		}
+		// The latest version is the highest, not the most recently written.
+		if got, want := get(Key{ID: 1, Kind: Detail}), string(detail(1, 3)); got != want {
+			t.Errorf("%v: got %v, want %v", backend, got, want)
+		}
+		if got, want := get(Key{ID: 1, Kind: Detail, Version: 2}), string(detail(1, 2)); got != want {
+			t.Errorf("%v: got %v, want %v", backend, got, want)
+		}
+		if _, err := st.Get(ctx, Key{ID: 1, Kind: Detail, Version: 4}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%v: missing or unexpected error: %v", backend, err)
+		}
+		if _, err := st.Get(ctx, Key{ID: 3, Kind: Detail}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%v: missing or unexpected error: %v", backend, err)
+		}
+		// Deleting the latest version makes the previous one the latest.
+		if err := st.Delete(ctx, Key{ID: 1, Kind: Detail, Version: 3}); err != nil {
+			t.Fatalf("%v: %v", backend, err)
+		}
+		if got, want := get(Key{ID: 1, Kind: Detail}), string(detail(1, 2)); got != want {
+			t.Errorf("%v: got %v, want %v", backend, got, want)
+		}
+		if err := st.Delete(ctx, Key{ID: 2, Kind: Detail}); err != nil {
+			t.Fatalf("%v: %v", backend, err)
+		}
+		if err := st.Close(); err != nil {
+			t.Fatalf("%v: %v", backend, err)
+		}
+		if st, err = OpenReadOnly(backend, dir); err != nil {
+			t.Fatalf("%v: %v", backend, err)
+		}
+		ids, _ = st.List(ctx, Detail)
+		if got, want := ids, []int64{1}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", backend, got, want)
+		}
+		versions, _ = st.Versions(ctx, 1, Detail)
+		if got, want := versions, []int{1, 2}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", backend, got, want)
+		}
+		if err := st.Put(ctx, Key{ID: 3, Kind: Detail, Version: 1}, detail(3, 1)); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%v: missing or unexpected error: %v", backend, err)
+		}
+		st.Close()
+	}
+	if _, err := Open("other", t.TempDir()); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestPutNoVersion(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range []string{FilesystemBackend, KVBackend} {
+		st, err := Open(backend, t.TempDir())
+		if err != nil {
+			t.Fatalf("%v: %v", backend, err)
+		}
+		if err := st.Put(ctx, Key{ID: 1, Kind: Detail, Version: 2}, detail(1, 2)); err != nil {
+			t.Fatalf("%v: %v", backend, err)
+		}
+		if err := st.Put(ctx, Key{ID: 1, Kind: Detail}, detail(1, 1)); !errors.Is(err, ErrNoVersion) {
+			t.Errorf("%v: missing or unexpected error: %v", backend, err)
+		}
+		if err := st.Put(ctx, Key{ID: 2, Kind: Detail}, detail(2, 1)); !errors.Is(err, ErrNoVersion) {
+			t.Errorf("%v: missing or unexpected error: %v", backend, err)
+		}
+		if data, err := st.Get(ctx, Key{ID: 1, Kind: Detail}); err != nil || string(data) != string(detail(1, 2)) {
+			t.Errorf("%v: got %s, %v", backend, data, err)
+		}
+		if ids, _ := st.List(ctx, Detail); !reflect.DeepEqual(ids, []int64{1}) {
+			t.Errorf("%v: got %v, want [1]", backend, ids)
+		}
+		if versions, _ := st.Versions(ctx, 1, Detail); !reflect.DeepEqual(versions, []int{2}) {
+			t.Errorf("%v: got %v, want [2]", backend, versions)
+		}
+		st.Close()
+	}
+}
+
+func TestFilesystemReadOnly(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "store")
+	if _, err := OpenReadOnly(FilesystemBackend, dir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing or unexpected error: %v", err)
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("%v was created: %v", dir, err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := OpenFilesystemReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := fs.List(ctx, Detail); err != nil || len(ids) != 0 {
+		t.Errorf("got %v, %v", ids, err)
+	}
+	if err := fs.Put(ctx, Key{ID: 1, Kind: Detail, Version: 1}, detail(1, 1)); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("missing or unexpected error: %v", err)
+	}
+	if err := fs.Delete(ctx, Key{ID: 1, Kind: Detail}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("missing or unexpected error: %v", err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%v was modified: %v", dir, entries)
+	}
+}
+
+func TestFilesystemManifest(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fs, err := OpenFilesystem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := Key{ID: 1, Kind: Detail, Version: 1}
+	for _, k := range []Key{key, {ID: 2, Kind: Detail, Version: 1}} {
+		if err := fs.Put(ctx, k, detail(k.ID, k.Version)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	fs.Close()
+
+	// A file written outside of the store, eg. by an earlier version,
+	// after its removal was recorded is not reported as corrupt.
+	if err := os.WriteFile(filepath.Join(dir, key.Name()), []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Damaged files are reported as corrupt.
+	other := filepath.Join(dir, Key{ID: 2, Kind: Detail}.Name())
+	if err := os.WriteFile(other, []byte(`{"payload":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fs, err = OpenFilesystemReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if _, err := fs.Get(ctx, Key{ID: 1, Kind: Detail}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := fs.Get(ctx, Key{ID: 2, Kind: Detail}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("missing or unexpected error: %v", err)
+	}
+	if _, err := fs.Get(ctx, Key{ID: 2, Kind: Detail, Version: 1}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}